@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/gorilla/mux"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long a login session lasts
+const sessionTTL = 30 * 24 * time.Hour
+
+// Valid account names
+var userRe = regexp.MustCompile(`^[a-z0-9_.-]{1,32}$`)
+
+// Local accounts, read from a file of <name>:<password hash> lines
+type userdb struct {
+	sync.Mutex
+	file  string
+	mtime time.Time
+	users map[string]string
+}
+
+// Accounts, and the key signing session cookies
+var (
+	users      *userdb
+	sessionKey []byte
+)
+
+// Open the accounts file — a missing file just means no accounts yet
+func newUserdb(file string) *userdb {
+	return &userdb{file: file, users: make(map[string]string)}
+}
+
+// Re-read the accounts file if it changed since we last looked
+func (db *userdb) reload() error {
+	fi, err := os.Stat(db.file)
+	if os.IsNotExist(err) {
+		db.users = make(map[string]string)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(db.mtime) {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(db.file)
+	if err != nil {
+		return err
+	}
+
+	u := make(map[string]string)
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if i := strings.Index(line, ":"); i > 0 {
+			u[line[:i]] = line[i+1:]
+		}
+	}
+
+	db.users = u
+	db.mtime = fi.ModTime()
+	return nil
+}
+
+// Check a name and password against the accounts file
+func (db *userdb) check(name, pass string) bool {
+	db.Lock()
+	err := db.reload()
+	hash, ok := db.users[name]
+	db.Unlock()
+
+	if err != nil || !ok {
+		// Burn the same time as a real check so names can't be probed
+		checkPassword(pass, dummyHash)
+		return false
+	}
+	return checkPassword(pass, hash)
+}
+
+// Whether an account exists
+func (db *userdb) exists(name string) bool {
+	db.Lock()
+	defer db.Unlock()
+
+	db.reload()
+	_, ok := db.users[name]
+	return ok
+}
+
+// Add or replace an account, rewriting the file
+func (db *userdb) set(name, pass string) error {
+	if !userRe.MatchString(name) {
+		return fmt.Errorf("bad user name %q: use 1-32 of a-z 0-9 _ . -", name)
+	}
+	hash, err := hashPassword(pass)
+	if err != nil {
+		return err
+	}
+
+	db.Lock()
+	defer db.Unlock()
+
+	if err := db.reload(); err != nil {
+		return err
+	}
+	db.users[name] = hash
+
+	names := make([]string, 0, len(db.users))
+	for n := range db.users {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, n := range names {
+		fmt.Fprintf(&buf, "%s:%s\n", n, db.users[n])
+	}
+
+	tmp := db.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, db.file)
+}
+
+// A hash of nothing in particular, checked against for unknown users
+var dummyHash, _ = hashPassword("")
+
+// Load the key signing session cookies, making a random one on first run,
+// so logins outlive a restart
+func initSessions(file string) error {
+	key, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return err
+		}
+
+		// Another process may have made one meanwhile — theirs wins
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			return initSessions(file)
+		}
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(key)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(file)
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	if len(key) != 32 {
+		return fmt.Errorf("%s: session key must be 32 bytes, got %d", file, len(key))
+	}
+	sessionKey = key
+	return nil
+}
+
+// Cookie value for a session: <name>|<expiry>|<hmac>
+func signSession(name string, exp time.Time) string {
+	v := name + "|" + strconv.FormatInt(exp.Unix(), 10)
+	mac := hmac.New(sha256.New, sessionKey)
+	mac.Write([]byte(v))
+	return base64.RawURLEncoding.EncodeToString([]byte(v + "|" + hex.EncodeToString(mac.Sum(nil))))
+}
+
+// Check a session cookie, returning the user it belongs to
+func verifySession(c string) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(c)
+	if err != nil {
+		return "", err
+	}
+
+	parts := strings.Split(string(b), "|")
+	if len(parts) != 3 {
+		return "", errors.New("malformed session")
+	}
+
+	mac := hmac.New(sha256.New, sessionKey)
+	mac.Write([]byte(parts[0] + "|" + parts[1]))
+	sum, err := hex.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sum, mac.Sum(nil)) {
+		return "", errors.New("bad session signature")
+	}
+
+	exp, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return "", errors.New("session expired")
+	}
+	return parts[0], nil
+}
+
+// The account making a request, from a session cookie or Basic auth, or ""
+func currentUser(r *http.Request) string {
+	if users == nil {
+		return ""
+	}
+
+	if c, err := r.Cookie("session"); err == nil {
+		if name, err := verifySession(c.Value); err == nil && users.exists(name) {
+			return name
+		}
+	}
+
+	if name, pass, ok := r.BasicAuth(); ok && name != "" && users.check(name, pass) {
+		return name
+	}
+	return ""
+}
+
+// Login page handler
+func handleLoginPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	loginTmpl.Execute(w, false)
+}
+
+// Login handler — checks the form's user and password and sets a session cookie
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	name, pass := r.FormValue("user"), r.FormValue("password")
+	if users == nil || !users.check(name, pass) {
+		if wantsHTML(r) {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusUnauthorized)
+			loginTmpl.Execute(w, true)
+			return
+		}
+		http.Error(w, "bad user name or password", http.StatusUnauthorized)
+		return
+	}
+
+	exp := time.Now().Add(sessionTTL)
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session",
+		Value:    signSession(name, exp),
+		Path:     "/",
+		Expires:  exp,
+		HttpOnly: true,
+		Secure:   proto == "https://",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	if wantsHTML(r) {
+		http.Redirect(w, r, "/~"+name, http.StatusSeeOther)
+		return
+	}
+	fmt.Fprintf(w, "logged in as %s\n", name)
+}
+
+// Logout handler — drops the session cookie
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: "session", Value: "", Path: "/", MaxAge: -1})
+	fmt.Fprintln(w, "logged out")
+}
+
+// User listing handler — a user's pastes, newest first
+// Owners see everything they pasted, others only the public pastes
+func handleUser(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["user"]
+	self := currentUser(r) == name
+
+	metas, err := listMetas(func(m *Meta) bool {
+		return m.Owner == name && (self || m.Vis() == Public)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, m := range metas {
+		exp := "never"
+		if !m.Expires.IsZero() {
+			exp = m.Expires.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s%s/%s\t%d\t%s\t%s\n", proto, r.Host, m.Key, m.Size, m.Created.Format(time.RFC3339), exp)
+	}
+}
+
+// Admin: add an account or change its password, reading the password from stdin
+func cmdUseradd(args []string) {
+	fs := flag.NewFlagSet("useradd", flag.ExitOnError)
+	fs.StringVar(&rootPath, "r", "./", "Website root directory")
+	fs.StringVar(&usersPath, "u", "", "Accounts file (default <root>/users)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gopaste useradd [-r root] [-u file] name < password")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if usersPath == "" {
+		usersPath = filepath.Join(rootPath, "users")
+	}
+
+	pass, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	pass = strings.TrimRight(pass, "\r\n")
+	if pass == "" {
+		if err != nil {
+			fatal(err)
+		}
+		fatal(errors.New("empty password"))
+	}
+
+	if err := newUserdb(usersPath).set(fs.Arg(0), pass); err != nil {
+		fatal(err)
+	}
+}
+
+// Login form for browsers
+var loginTmpl = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Log in</title>
+</head>
+<body>
+<form method="POST" action="/login">
+{{if .}}<p>Bad user name or password.</p>
+{{end}}<p><label>User: <input name="user" autofocus></label></p>
+<p><label>Password: <input type="password" name="password"></label></p>
+<input type="submit" value="Log in">
+</form>
+</body>
+</html>
+`))
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// A session signed before a restart is still good after it
+func TestSessionKey(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "session.key")
+	defer func(old []byte) { sessionKey = old }(sessionKey)
+
+	if err := initSessions(file); err != nil {
+		t.Fatal(err)
+	}
+	first := sessionKey
+	c := signSession("alice", time.Now().Add(time.Hour))
+
+	sessionKey = nil
+	if err := initSessions(file); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sessionKey, first) {
+		t.Fatal("session key changed across a restart")
+	}
+	if name, err := verifySession(c); err != nil || name != "alice" {
+		t.Fatalf("session after restart: %q, %v", name, err)
+	}
+
+	ioutil.WriteFile(file, []byte("short"), 0600)
+	if err := initSessions(file); err == nil {
+		t.Fatal("accepted a short session key")
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Parse an admin command's flags and open the configured store
+func adminFlags(name, usage string, args []string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	storeFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: gopaste %s [-r root] [-E] [-K keyfile] %s\n", name, usage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if _, err := openStore(); err != nil {
+		fatal(err)
+	}
+	return fs
+}
+
+// Every paste in the store, expired or not, newest first
+func allMetas() ([]*Meta, error) {
+	names, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var metas []*Meta
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".paste") {
+			continue
+		}
+		m, err := readMeta(strings.TrimSuffix(name, ".paste"))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "gopaste: %s: %v\n", name, err)
+			continue
+		}
+		metas = append(metas, m)
+	}
+
+	sort.Slice(metas, func(i, j int) bool { return metas[i].Created.After(metas[j].Created) })
+	return metas, nil
+}
+
+// Short flags describing a paste: bundle, encrypted, password
+func metaFlags(m *Meta) string {
+	f := ""
+	if m.Bundle() {
+		f += "b"
+	}
+	if m.Encrypted {
+		f += "e"
+	}
+	if m.Pass != "" {
+		f += "p"
+	}
+	if m.Expired() {
+		f += "x"
+	}
+	if f == "" {
+		f = "-"
+	}
+	return f
+}
+
+// Format a time for listings, "-" when unset
+func fmtTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+// Admin: list pastes
+func cmdLs(args []string) {
+	adminFlags("ls", "", args)
+
+	metas, err := allMetas()
+	if err != nil {
+		fatal(err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tSIZE\tCREATED\tEXPIRES\tVISIBILITY\tOWNER\tFLAGS")
+	for _, m := range metas {
+		owner := m.Owner
+		if owner == "" {
+			owner = m.Label
+		}
+		if owner == "" {
+			owner = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n", m.Key, m.Size, fmtTime(m.Created), fmtTime(m.Expires), m.Vis(), owner, metaFlags(m))
+	}
+	tw.Flush()
+}
+
+// Admin: delete pastes by key
+func cmdRm(args []string) {
+	fs := adminFlags("rm", "key...", args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	status := 0
+	for _, key := range fs.Args() {
+		if err := deletePaste(key, nil); err != nil {
+			fmt.Fprintf(os.Stderr, "gopaste: %s: %v\n", key, err)
+			status = 1
+		}
+	}
+	os.Exit(status)
+}
+
+// Admin: delete expired pastes now
+func cmdGc(args []string) {
+	adminFlags("gc", "", args)
+
+	n, err := reap()
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Printf("removed %d expired pastes\n", n)
+}
+
+// Admin: summarize the store
+func cmdStats(args []string) {
+	adminFlags("stats", "", args)
+
+	metas, err := allMetas()
+	if err != nil {
+		fatal(err)
+	}
+
+	var total int64
+	var bundles, encrypted, protected, owned, expired int
+	vis := make(map[string]int)
+	for _, m := range metas {
+		total += m.Size
+		vis[m.Vis()]++
+		if m.Bundle() {
+			bundles++
+		}
+		if m.Encrypted {
+			encrypted++
+		}
+		if m.Pass != "" {
+			protected++
+		}
+		if m.Owner != "" {
+			owned++
+		}
+		if m.Expired() {
+			expired++
+		}
+	}
+
+	fmt.Printf("pastes\t%d\n", len(metas))
+	fmt.Printf("bytes\t%d\n", total)
+	for _, v := range []string{Public, Unlisted, Private} {
+		fmt.Printf("%s\t%d\n", v, vis[v])
+	}
+	fmt.Printf("bundles\t%d\n", bundles)
+	fmt.Printf("encrypted\t%d\n", encrypted)
+	fmt.Printf("passworded\t%d\n", protected)
+	fmt.Printf("owned\t%d\n", owned)
+	fmt.Printf("expired\t%d\n", expired)
+	if len(metas) > 0 {
+		fmt.Printf("newest\t%s\n", fmtTime(metas[0].Created))
+		fmt.Printf("oldest\t%s\n", fmtTime(metas[len(metas)-1].Created))
+	}
+}
+
+// Admin: recompute content hashes and report pastes that don't match their key
+func cmdVerify(args []string) {
+	adminFlags("verify", "", args)
+
+	names, err := store.List()
+	if err != nil {
+		fatal(err)
+	}
+	have := make(map[string]bool)
+	for _, name := range names {
+		have[name] = true
+	}
+
+	var ok, bad int
+	report := func(key, format string, a ...interface{}) {
+		fmt.Printf("%s: %s\n", key, fmt.Sprintf(format, a...))
+		bad++
+	}
+
+	for _, name := range names {
+		key := strings.TrimSuffix(name, ".meta")
+		if strings.HasSuffix(name, ".meta") && !have[key+".paste"] {
+			report(key, "metadata without a paste")
+			continue
+		}
+		if !strings.HasSuffix(name, ".paste") {
+			continue
+		}
+
+		key = strings.TrimSuffix(name, ".paste")
+		if problem := verifyPaste(key); problem != "" {
+			report(key, "%s", problem)
+			continue
+		}
+		ok++
+	}
+
+	if blobs != nil {
+		problems, err := blobs.audit()
+		if err != nil {
+			fatal(err)
+		}
+		for _, p := range problems {
+			fmt.Println(p)
+			bad++
+		}
+	}
+
+	fmt.Printf("%d ok, %d corrupt\n", ok, bad)
+	if bad > 0 {
+		os.Exit(1)
+	}
+}
+
+// Check one paste against its key and metadata, describing any problem
+func verifyPaste(key string) string {
+	data, err := readPaste(key)
+	if err != nil {
+		return err.Error()
+	}
+	m, err := readMeta(key)
+	if err != nil {
+		return "metadata: " + err.Error()
+	}
+
+	if got := expectedKey(key, data, m.Nonce); got != key {
+		return fmt.Sprintf("content hashes to %s", got)
+	}
+	if m.Size != 0 && m.Size != int64(len(data)) {
+		return fmt.Sprintf("size %d, metadata says %d", len(data), m.Size)
+	}
+	if m.Bundle() {
+		if _, err := unpackBundle(data); err != nil {
+			return "bundle: " + err.Error()
+		}
+	}
+	return ""
+}
+
+// Admin: move a flat paste directory into the sharded layout, in place
+func cmdReshard(args []string) {
+	adminFlags("reshard", "", args)
+	if !strings.HasPrefix(storeSpec, "dir:") && !strings.HasPrefix(storeSpec, "shard:") {
+		fatal(fmt.Errorf("reshard needs a directory store, not %q", storeSpec))
+	}
+
+	n, err := reshard(pastePath)
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Printf("moved %d files into shards; serve with -S shard:%s\n", n, pastePath)
+}
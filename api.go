@@ -0,0 +1,444 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A paste as the JSON API describes it — never its password hash, nonce or token hash
+type apiPaste struct {
+	Key         string     `json:"key"`
+	URL         string     `json:"url"`
+	RawURL      string     `json:"raw_url"`
+	Created     time.Time  `json:"created"`
+	Expires     *time.Time `json:"expires"`
+	Size        int64      `json:"size"`
+	Files       []string   `json:"files,omitempty"`
+	Lang        string     `json:"lang,omitempty"`
+	Visibility  string     `json:"visibility"`
+	Owner       string     `json:"owner,omitempty"`
+	Protected   bool       `json:"protected"`
+	Encrypted   bool       `json:"encrypted"`
+	Burn        bool       `json:"burn"`
+	DeleteToken string     `json:"delete_token,omitempty"`
+}
+
+// A create request sent as JSON rather than a form
+type apiCreate struct {
+	Content    string `json:"content"`
+	Expire     string `json:"expire"`
+	Lang       string `json:"lang"`
+	Visibility string `json:"visibility"`
+	Password   string `json:"password"`
+	Encrypted  bool   `json:"encrypted"`
+	Burn       bool   `json:"burn"`
+	Token      bool   `json:"token"`
+}
+
+// Register the JSON API under /api/v1
+func apiRoutes(r *mux.Router) {
+	r.HandleFunc("/api/v1/openapi.json", handleAPISpec).Methods("GET")
+	r.HandleFunc("/api/v1/pastes", handleAPIList).Methods("GET")
+	r.HandleFunc("/api/v1/pastes", handleAPICreate).Methods("POST")
+	r.HandleFunc("/api/v1/pastes/{pasteId}", handleAPIMeta).Methods("GET")
+	r.HandleFunc("/api/v1/pastes/{pasteId}", handleAPIDelete).Methods("DELETE")
+	r.HandleFunc("/api/v1/pastes/{pasteId}/raw", handleAPIRaw).Methods("GET")
+
+	// Anything else under /api answers in JSON too, not as a paste lookup
+	r.PathPrefix("/api/").HandlerFunc(handleAPINotFound)
+}
+
+// Describe a paste for the API
+func apiDescribe(r *http.Request, m *Meta) apiPaste {
+	base := proto + r.Host
+	p := apiPaste{
+		Key:        m.Key,
+		URL:        base + "/" + m.Key,
+		RawURL:     base + "/api/v1/pastes/" + m.Key + "/raw",
+		Created:    m.Created,
+		Size:       m.Size,
+		Files:      m.Files,
+		Lang:       m.Lang,
+		Visibility: m.Vis(),
+		Owner:      m.Owner,
+		Protected:  m.Pass != "",
+		Encrypted:  m.Encrypted,
+		Burn:       m.Burn,
+	}
+	if !m.Expires.IsZero() {
+		exp := m.Expires
+		p.Expires = &exp
+	}
+	return p
+}
+
+// Answer with a JSON document
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// Answer with an error as JSON: {"error": "...", "status": 404}
+func writeAPIError(w http.ResponseWriter, err error) {
+	e := errorStatus(err)
+	if e.auth != "" {
+		w.Header().Set("WWW-Authenticate", e.auth)
+	}
+	writeJSON(w, e.code, struct {
+		Error  string `json:"error"`
+		Status int    `json:"status"`
+	}{e.msg, e.code})
+}
+
+// API: create a paste from a form, as POST / takes, or a JSON body
+func handleAPICreate(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := apiForm(w, r); err != nil {
+			writeAPIError(w, err)
+			return
+		}
+	}
+
+	m, token, err := createPaste(w, r)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	writeCreated(w, r, m, token)
+}
+
+// Answer a create with 201, the paste's URL as Location and its description
+func writeCreated(w http.ResponseWriter, r *http.Request, m *Meta, token string) {
+	p := apiDescribe(r, m)
+	p.DeleteToken = token
+	w.Header().Set("Location", p.URL)
+	writeJSON(w, http.StatusCreated, p)
+}
+
+// Turn a JSON create request into the form values createPaste reads
+func apiForm(w http.ResponseWriter, r *http.Request) error {
+	limit := maxB
+	if apiKeys != nil {
+		limit = apiKeys.maxSize()
+	}
+
+	// JSON escaping can double the size of a paste, so allow for it here
+	var req apiCreate
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 2*limit+4096))
+	if err := dec.Decode(&req); err != nil {
+		return httpErrorf(http.StatusBadRequest, "bad request body: %v", err)
+	}
+
+	form := url.Values{}
+	set := func(name, val string) {
+		if val != "" {
+			form.Set(name, val)
+		}
+	}
+	flag := func(name string, on bool) {
+		if on {
+			form.Set(name, "1")
+		}
+	}
+	set(formVal, req.Content)
+	set("expire", req.Expire)
+	set("lang", req.Lang)
+	set("visibility", req.Visibility)
+	set("password", req.Password)
+	flag("encrypted", req.Encrypted)
+	flag("burn", req.Burn)
+	flag("token", req.Token)
+
+	// The API key may come as a query parameter alongside the JSON body
+	if k := r.URL.Query().Get("apikey"); k != "" {
+		form.Set("apikey", k)
+	}
+
+	r.PostForm = form
+	r.Form = form
+	return nil
+}
+
+// API: a paste's metadata
+func handleAPIMeta(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["pasteId"]
+	m, _, err := lookupPaste(r, key)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, apiDescribe(r, m))
+}
+
+// API: a paste's content, exactly as stored
+func handleAPIRaw(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["pasteId"]
+	m, data, err := lookupPaste(r, key)
+	if err == nil && m.Burn && !claimPaste(key, r) {
+		err = httpErrorf(http.StatusNotFound, "[%s] not found", key)
+	}
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	pastesViewed.inc()
+
+	switch {
+	case m.Bundle():
+		w.Header().Set("Content-Type", "application/x-tar")
+	case m.Encrypted:
+		w.Header().Set("Content-Type", "application/octet-stream")
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+}
+
+// API: delete a paste with its delete token, as its owner or with its API key
+func handleAPIDelete(w http.ResponseWriter, r *http.Request) {
+	if err := deleteRequested(r, mux.Vars(r)["pasteId"]); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// API: list pastes, newest first — public ones, or with ?mine=1 the requester's own
+func handleAPIList(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	page, _ := strconv.Atoi(q.Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	mine := q.Get("mine") != ""
+	user := q.Get("user")
+	lang := strings.ToLower(q.Get("lang"))
+
+	// Resolve the requester once — checking a password per paste would cost a key derivation each
+	var me, label string
+	if mine {
+		me = currentUser(r)
+		if apiKeys != nil {
+			if k := apiKeys.lookup(requestToken(r)); k != nil {
+				label = k.Label
+			}
+		}
+		if me == "" && label == "" {
+			writeAPIError(w, httpErrorf(http.StatusUnauthorized, "listing your own pastes needs an account or API key"))
+			return
+		}
+	}
+
+	metas, err := listMetas(func(m *Meta) bool {
+		owned := (me != "" && m.Owner == me) || (label != "" && m.Label == label)
+		if (mine && !owned) || (!mine && m.Vis() != Public) {
+			return false
+		}
+		return (user == "" || m.Owner == user) && (lang == "" || m.Lang == lang)
+	})
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	start := (page - 1) * recentPage
+	if start > len(metas) {
+		start = len(metas)
+	}
+	end := start + recentPage
+	if end > len(metas) {
+		end = len(metas)
+	}
+
+	list := struct {
+		Pastes []apiPaste `json:"pastes"`
+		Page   int        `json:"page"`
+		Next   int        `json:"next,omitempty"`
+	}{Pastes: []apiPaste{}, Page: page}
+	for _, m := range metas[start:end] {
+		list.Pastes = append(list.Pastes, apiDescribe(r, m))
+	}
+	if end < len(metas) {
+		list.Next = page + 1
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// API: unknown endpoints and methods
+func handleAPINotFound(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, httpErrorf(http.StatusNotFound, "no such endpoint: %s %s", r.Method, r.URL.Path))
+}
+
+// API: the OpenAPI description of all of the above
+func handleAPISpec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, openAPISpec, proto+r.Host)
+}
+
+// OpenAPI 3.0 document for /api/v1 — %s is the server URL
+const openAPISpec = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "gopaste",
+    "version": "1",
+    "description": "JSON API for creating, reading, listing and deleting pastes."
+  },
+  "servers": [{"url": "%s/api/v1"}],
+  "components": {
+    "securitySchemes": {
+      "apiKey": {"type": "http", "scheme": "bearer", "description": "API key, when the server requires them"},
+      "account": {"type": "http", "scheme": "basic", "description": "Account name and password"},
+      "pastePassword": {"type": "http", "scheme": "basic", "description": "Any user name and the paste's password"}
+    },
+    "parameters": {
+      "key": {"name": "key", "in": "path", "required": true, "schema": {"type": "string"}}
+    },
+    "schemas": {
+      "Paste": {
+        "type": "object",
+        "required": ["key", "url", "raw_url", "created", "expires", "size", "visibility", "protected", "encrypted", "burn"],
+        "properties": {
+          "key": {"type": "string"},
+          "url": {"type": "string", "format": "uri"},
+          "raw_url": {"type": "string", "format": "uri"},
+          "created": {"type": "string", "format": "date-time"},
+          "expires": {"type": "string", "format": "date-time", "nullable": true},
+          "size": {"type": "integer"},
+          "files": {"type": "array", "items": {"type": "string"}},
+          "lang": {"type": "string"},
+          "visibility": {"type": "string", "enum": ["public", "unlisted", "private"]},
+          "owner": {"type": "string"},
+          "protected": {"type": "boolean"},
+          "encrypted": {"type": "boolean"},
+          "burn": {"type": "boolean"},
+          "delete_token": {"type": "string", "description": "Only in the create response, when token was asked for"}
+        }
+      },
+      "Create": {
+        "type": "object",
+        "required": ["content"],
+        "properties": {
+          "content": {"type": "string"},
+          "expire": {"type": "string", "example": "7d"},
+          "lang": {"type": "string"},
+          "visibility": {"type": "string", "enum": ["public", "unlisted", "private"]},
+          "password": {"type": "string"},
+          "encrypted": {"type": "boolean"},
+          "burn": {"type": "boolean"},
+          "token": {"type": "boolean", "description": "Return a delete token"}
+        }
+      },
+      "List": {
+        "type": "object",
+        "required": ["pastes", "page"],
+        "properties": {
+          "pastes": {"type": "array", "items": {"$ref": "#/components/schemas/Paste"}},
+          "page": {"type": "integer"},
+          "next": {"type": "integer", "description": "Next page, when there is one"}
+        }
+      },
+      "Error": {
+        "type": "object",
+        "required": ["error", "status"],
+        "properties": {
+          "error": {"type": "string"},
+          "status": {"type": "integer"}
+        }
+      }
+    },
+    "responses": {
+      "Error": {
+        "description": "Error",
+        "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}
+      }
+    }
+  },
+  "security": [{}, {"apiKey": []}, {"account": []}],
+  "paths": {
+    "/pastes": {
+      "get": {
+        "summary": "List public pastes, or your own with mine",
+        "parameters": [
+          {"name": "mine", "in": "query", "schema": {"type": "boolean"}},
+          {"name": "user", "in": "query", "schema": {"type": "string"}},
+          {"name": "lang", "in": "query", "schema": {"type": "string"}},
+          {"name": "page", "in": "query", "schema": {"type": "integer", "minimum": 1}}
+        ],
+        "responses": {
+          "200": {"description": "Pastes, newest first", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/List"}}}},
+          "401": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "post": {
+        "summary": "Create a paste",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {"schema": {"$ref": "#/components/schemas/Create"}},
+            "multipart/form-data": {"schema": {"type": "object", "description": "The same fields as POST /, with file parts making a bundle"}},
+            "application/x-www-form-urlencoded": {"schema": {"type": "object", "description": "The same fields as POST /"}}
+          }
+        },
+        "responses": {
+          "201": {
+            "description": "Created",
+            "headers": {"Location": {"schema": {"type": "string"}}},
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Paste"}}}
+          },
+          "400": {"$ref": "#/components/responses/Error"},
+          "401": {"$ref": "#/components/responses/Error"},
+          "413": {"$ref": "#/components/responses/Error"},
+          "429": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/pastes/{key}": {
+      "parameters": [{"$ref": "#/components/parameters/key"}],
+      "get": {
+        "summary": "A paste's metadata",
+        "security": [{}, {"apiKey": []}, {"account": []}, {"pastePassword": []}],
+        "responses": {
+          "200": {"description": "Metadata", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Paste"}}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "summary": "Delete a paste",
+        "parameters": [
+          {"name": "X-Delete-Token", "in": "header", "schema": {"type": "string"}},
+          {"name": "token", "in": "query", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "204": {"description": "Deleted"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/pastes/{key}/raw": {
+      "parameters": [{"$ref": "#/components/parameters/key"}],
+      "get": {
+        "summary": "A paste's content as stored — bundles as a tar file",
+        "security": [{}, {"apiKey": []}, {"account": []}, {"pastePassword": []}],
+        "responses": {
+          "200": {"description": "Content", "content": {"text/plain": {}, "application/x-tar": {}, "application/octet-stream": {}}},
+          "401": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    }
+  }
+}
+`
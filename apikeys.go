@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An upload key from the keys file
+type apiKey struct {
+	Token string
+	Label string
+	Quota int   // pastes per day, 0 for unlimited
+	MaxB  int64 // size limit overriding -s, 0 for the default
+}
+
+// Keys loaded from the keys file, reloaded when the file changes
+type keyring struct {
+	sync.Mutex
+	file  string
+	mtime time.Time
+	keys  []apiKey
+	day   string
+	used  map[string]int
+}
+
+// Upload keys, nil unless running in authenticated mode
+var apiKeys *keyring
+
+// Load a keys file — one key per line:
+//
+//	<token> <label> [quota=<pastes per day>] [max=<bytes>]
+func newKeyring(file string) (*keyring, error) {
+	kr := &keyring{file: file, used: make(map[string]int)}
+	return kr, kr.reload()
+}
+
+// Re-read the keys file if it changed since we last looked
+func (kr *keyring) reload() error {
+	fi, err := os.Stat(kr.file)
+	if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(kr.mtime) {
+		return nil
+	}
+
+	f, err := os.Open(kr.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var keys []apiKey
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || fields[0][0] == '#' {
+			continue
+		}
+		if len(fields) < 2 {
+			return fmt.Errorf("%s:%d: want <token> <label> [quota=N] [max=N]", kr.file, n)
+		}
+
+		k := apiKey{Token: fields[0], Label: fields[1]}
+		for _, opt := range fields[2:] {
+			var err error
+			switch {
+			case strings.HasPrefix(opt, "quota="):
+				k.Quota, err = strconv.Atoi(opt[len("quota="):])
+			case strings.HasPrefix(opt, "max="):
+				k.MaxB, err = strconv.ParseInt(opt[len("max="):], 10, 64)
+			default:
+				err = fmt.Errorf("unknown option %q", opt)
+			}
+			if err != nil {
+				return fmt.Errorf("%s:%d: %v", kr.file, n, err)
+			}
+		}
+		keys = append(keys, k)
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+
+	kr.keys = keys
+	kr.mtime = fi.ModTime()
+	return nil
+}
+
+// Largest upload any key may make, so the body can be limited before parsing
+func (kr *keyring) maxSize() int64 {
+	kr.Lock()
+	defer kr.Unlock()
+
+	if err := kr.reload(); err != nil {
+		return maxB
+	}
+	max := maxB
+	for _, k := range kr.keys {
+		if k.MaxB > max {
+			max = k.MaxB
+		}
+	}
+	return max
+}
+
+// Find the key for a token, comparing in constant time
+func (kr *keyring) lookup(token string) *apiKey {
+	kr.Lock()
+	defer kr.Unlock()
+
+	var found *apiKey
+	for i := range kr.keys {
+		if subtle.ConstantTimeCompare([]byte(kr.keys[i].Token), []byte(token)) == 1 {
+			k := kr.keys[i]
+			found = &k
+		}
+	}
+	return found
+}
+
+// Count an upload against a key's daily quota, refusing it if exhausted
+func (kr *keyring) take(k *apiKey) bool {
+	kr.Lock()
+	defer kr.Unlock()
+
+	today := time.Now().Format("2006-01-02")
+	if today != kr.day {
+		kr.day = today
+		kr.used = make(map[string]int)
+	}
+
+	if k.Quota > 0 && kr.used[k.Token] >= k.Quota {
+		return false
+	}
+	kr.used[k.Token]++
+	return true
+}
+
+// Upload key offered by a client, as a bearer token or apikey form value
+func requestToken(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimSpace(h[len("Bearer "):])
+	}
+	return r.FormValue("apikey")
+}
+
+// Check an upload's key, size and quota in authenticated mode
+func authorizeUpload(r *http.Request, size int64) (*apiKey, error) {
+	if apiKeys == nil {
+		return nil, nil
+	}
+
+	k := apiKeys.lookup(requestToken(r))
+	if k == nil {
+		e := httpErrorf(http.StatusUnauthorized, "a valid API key is required to paste")
+		e.auth = "Bearer"
+		return nil, e
+	}
+
+	limit := maxB
+	if k.MaxB > 0 {
+		limit = k.MaxB
+	}
+	if size > limit {
+		return nil, httpErrorf(http.StatusRequestEntityTooLarge, "paste exceeds %d bytes", limit)
+	}
+
+	if !apiKeys.take(k) {
+		return nil, httpErrorf(http.StatusTooManyRequests, "daily quota of %d pastes used up", k.Quota)
+	}
+
+	return k, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeKeys(t *testing.T, file, keys string, mtime time.Time) {
+	t.Helper()
+	if err := ioutil.WriteFile(file, []byte(keys), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(file, mtime, mtime)
+}
+
+func TestKeyring(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys")
+	now := time.Now()
+	writeKeys(t, file, `# comment
+
+alpha ci quota=2
+beta laptop max=500
+gamma build quota=1 max=50
+`, now)
+
+	kr, err := newKeyring(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kr.keys) != 3 {
+		t.Fatalf("%d keys, want 3", len(kr.keys))
+	}
+	if k := kr.lookup("gamma"); k == nil || k.Label != "build" || k.Quota != 1 || k.MaxB != 50 {
+		t.Fatalf("gamma: %+v", k)
+	}
+	if kr.lookup("delta") != nil || kr.lookup("") != nil || kr.lookup("alph") != nil {
+		t.Fatal("looked up a token that isn't in the file")
+	}
+
+	defer func(old int64) { maxB = old }(maxB)
+	maxB = 100
+	if got := kr.maxSize(); got != 500 {
+		t.Fatalf("maxSize() = %d, want the largest key limit", got)
+	}
+
+	// An edit takes effect on the next look
+	writeKeys(t, file, "delta new\n", now.Add(time.Second))
+	if kr.maxSize(); kr.lookup("alpha") != nil || kr.lookup("delta") == nil {
+		t.Fatal("keys file change not picked up")
+	}
+
+	for _, bad := range []string{
+		"lonely\n",
+		"alpha ci quota=x\n",
+		"alpha ci max=-\n",
+		"alpha ci colour=blue\n",
+	} {
+		writeKeys(t, file, bad, now.Add(2*time.Second))
+		if _, err := newKeyring(file); err == nil || !strings.Contains(err.Error(), file+":1:") {
+			t.Errorf("keys file %q: got %v, want an error naming the line", bad, err)
+		}
+	}
+}
+
+func TestKeyringQuota(t *testing.T) {
+	kr := &keyring{used: make(map[string]int)}
+	limited := &apiKey{Token: "a", Quota: 2}
+	open := &apiKey{Token: "b"}
+
+	for i, want := range []bool{true, true, false, false} {
+		if got := kr.take(limited); got != want {
+			t.Fatalf("upload %d: take = %v, want %v", i+1, got, want)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if !kr.take(open) {
+			t.Fatal("a key without a quota ran out")
+		}
+	}
+
+	// Counts start over each day
+	kr.day = "2000-01-01"
+	if !kr.take(limited) {
+		t.Fatal("quota not reset on a new day")
+	}
+}
+
+func TestAuthorizeUpload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys")
+	writeKeys(t, file, "small tiny quota=1 max=10\nbig large\n", time.Now())
+	kr, err := newKeyring(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(old *keyring, oldMax int64) { apiKeys, maxB = old, oldMax }(apiKeys, maxB)
+	apiKeys, maxB = nil, 100
+
+	req := func(token, form string) *http.Request {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(form))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		return r
+	}
+
+	if k, err := authorizeUpload(req("", ""), 1000); k != nil || err != nil {
+		t.Fatalf("open mode: %v, %v", k, err)
+	}
+
+	apiKeys = kr
+	tests := []struct {
+		r    *http.Request
+		size int64
+		code int
+	}{
+		{req("", ""), 1, http.StatusUnauthorized},
+		{req("wrong", ""), 1, http.StatusUnauthorized},
+		{req("big", ""), 100, 0},
+		{req("big", ""), 101, http.StatusRequestEntityTooLarge},
+		{req("", "apikey=small"), 11, http.StatusRequestEntityTooLarge},
+		{req("", "apikey=small"), 10, 0},
+		{req("small", ""), 1, http.StatusTooManyRequests},
+	}
+	for i, tt := range tests {
+		_, err := authorizeUpload(tt.r, tt.size)
+		code := 0
+		if err != nil {
+			code = errorStatus(err).code
+		}
+		if code != tt.code {
+			t.Errorf("upload %d: %v, want status %d", i+1, err, tt.code)
+		}
+	}
+
+	_, err = authorizeUpload(req("", ""), 1)
+	if e := errorStatus(err); e.auth != "Bearer" {
+		t.Fatalf("missing key answered without a Bearer challenge: %+v", e)
+	}
+}
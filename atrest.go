@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Header on every value sealed at rest, followed by key ID, nonce and ciphertext
+var atRestMagic = []byte("gpaead1\n")
+
+// Length of the key ID — a prefix of sha256(key)
+const keyIDLen = 8
+
+// An at-rest key and its ID
+type restKey struct {
+	id   []byte
+	aead cipher.AEAD
+}
+
+// Encrypts every value on its way into the wrapped store
+// The first key seals new values; any key may open old ones
+type cryptStore struct {
+	Store
+	sync.Mutex // orders writes against rewrapping
+	keys       []restKey
+	writers    sync.Locker // keeps other processes' writes out while rewrapping, if set
+}
+
+// Wrap a store with encryption under the given keys, current key first
+func newCryptStore(s Store, raw [][]byte) (*cryptStore, error) {
+	if len(raw) == 0 {
+		return nil, errors.New("no encryption keys")
+	}
+
+	cs := &cryptStore{Store: s}
+	for _, k := range raw {
+		if len(k) != 32 {
+			return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(k))
+		}
+		aead, err := newGCM(k)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(k)
+		cs.keys = append(cs.keys, restKey{sum[:keyIDLen], aead})
+	}
+	return cs, nil
+}
+
+func (s *cryptStore) Get(name string) ([]byte, error) {
+	data, err := s.Store.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return s.open(name, data)
+}
+
+func (s *cryptStore) Put(name string, data []byte) error {
+	sealed, err := s.seal(name, data)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	return s.Store.Put(name, sealed)
+}
+
+func (s *cryptStore) Delete(name string) error {
+	s.Lock()
+	defer s.Unlock()
+	return s.Store.Delete(name)
+}
+
+// Seal under the current key, binding the value to its name
+func (s *cryptStore) seal(name string, data []byte) ([]byte, error) {
+	k := s.keys[0]
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(atRestMagic)+keyIDLen+len(nonce)+len(data)+k.aead.Overhead())
+	out = append(out, atRestMagic...)
+	out = append(out, k.id...)
+	out = append(out, nonce...)
+	return k.aead.Seal(out, nonce, data, []byte(name)), nil
+}
+
+// Open a sealed value — values written before encryption was enabled pass through
+func (s *cryptStore) open(name string, data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, atRestMagic) {
+		return data, nil
+	}
+
+	rest := data[len(atRestMagic):]
+	if len(rest) < keyIDLen {
+		return nil, fmt.Errorf("%s: truncated", name)
+	}
+	id, rest := rest[:keyIDLen], rest[keyIDLen:]
+
+	for _, k := range s.keys {
+		if !bytes.Equal(k.id, id) {
+			continue
+		}
+		n := k.aead.NonceSize()
+		if len(rest) < n {
+			return nil, fmt.Errorf("%s: truncated", name)
+		}
+		plain, err := k.aead.Open(nil, rest[:n], rest[n:], []byte(name))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		return plain, nil
+	}
+
+	return nil, fmt.Errorf("%s: sealed with unknown key %x", name, id)
+}
+
+// Whether a raw stored value is sealed under the current key
+func (s *cryptStore) current(data []byte) bool {
+	if !bytes.HasPrefix(data, atRestMagic) {
+		return false
+	}
+	rest := data[len(atRestMagic):]
+	return len(rest) >= keyIDLen && bytes.Equal(rest[:keyIDLen], s.keys[0].id)
+}
+
+// Re-encrypt everything not sealed under the current key, including plaintext
+func (s *cryptStore) rewrap() {
+	names, err := s.Store.List()
+	if err != nil {
+		log.Printf("rewrap: %v", err)
+		return
+	}
+
+	var n int
+	for _, name := range names {
+		data, err := s.Store.Get(name)
+		if err != nil || s.current(data) {
+			continue
+		}
+
+		plain, err := s.open(name, data)
+		var done bool
+		if err == nil {
+			done, err = s.reseal(name, data, plain)
+		}
+		if err != nil {
+			log.Printf("rewrap: %v", err)
+			continue
+		}
+		if done {
+			n++
+		}
+	}
+
+	if n > 0 {
+		log.Printf("rewrap: re-encrypted %d files under the current key", n)
+	}
+}
+
+// Seal a value afresh under the current key, unless it was rewritten or
+// deleted since it was read as old — a write racing the rewrap always wins
+func (s *cryptStore) reseal(name string, old, plain []byte) (bool, error) {
+	sealed, err := s.seal(name, plain)
+	if err != nil {
+		return false, err
+	}
+
+	if s.writers != nil {
+		s.writers.Lock()
+		defer s.writers.Unlock()
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	now, err := s.Store.Get(name)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil || !bytes.Equal(now, old) {
+		return false, err
+	}
+	return true, s.Store.Put(name, sealed)
+}
+
+// How many values to look at for a seal when encryption is off
+const sealedSample = 8
+
+// Refuse a store that was written with encryption on when it's now off —
+// sealed metadata would read as garbage and every paste answer as missing
+func checkUnsealed(s Store) error {
+	names, err := s.List()
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(names) > sealedSample {
+		names = names[:sealedSample]
+	}
+	for _, name := range names {
+		data, err := s.Get(name)
+		if err == nil && bytes.HasPrefix(data, atRestMagic) {
+			return fmt.Errorf("%s is encrypted at rest: run with -E and its key", name)
+		}
+	}
+	return nil
+}
+
+// Load at-rest keys from a key file, or $GOPASTE_KEY and $GOPASTE_OLD_KEYS
+// Keys are base64-encoded 32-byte values; the first one is current
+func loadRestKeys(file string) ([][]byte, error) {
+	var enc []string
+
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key file: %v", err)
+		}
+		defer f.Close()
+
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			line := strings.TrimSpace(sc.Text())
+			if line != "" && line[0] != '#' {
+				enc = append(enc, line)
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	} else if k := os.Getenv("GOPASTE_KEY"); k != "" {
+		enc = append(enc, k)
+		for _, old := range strings.Split(os.Getenv("GOPASTE_OLD_KEYS"), ",") {
+			if old = strings.TrimSpace(old); old != "" {
+				enc = append(enc, old)
+			}
+		}
+	}
+
+	if len(enc) == 0 {
+		return nil, errors.New("encryption at rest is enabled but no key was given: use -K <file> or set $GOPASTE_KEY")
+	}
+
+	var keys [][]byte
+	for i, e := range enc {
+		k, err := base64.StdEncoding.DecodeString(e)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %d: %v", i+1, err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
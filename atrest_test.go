@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func newTestCrypt(t *testing.T, s Store, keys ...[]byte) *cryptStore {
+	t.Helper()
+	cs, err := newCryptStore(s, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cs
+}
+
+func TestCryptSeal(t *testing.T) {
+	cs := newTestCrypt(t, &dirStore{dir: t.TempDir()}, testKey(1))
+
+	sealed, err := cs.seal("a.paste", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(sealed, atRestMagic) || bytes.Contains(sealed, []byte("secret")) {
+		t.Fatalf("sealed value %q", sealed)
+	}
+	if again, _ := cs.seal("a.paste", []byte("secret")); bytes.Equal(again, sealed) {
+		t.Fatal("two seals share a nonce")
+	}
+	if got, err := cs.open("a.paste", sealed); err != nil || string(got) != "secret" {
+		t.Fatalf("open: %q, %v", got, err)
+	}
+
+	// A sealed value only opens under the name it was sealed for
+	if _, err := cs.open("b.paste", sealed); err == nil {
+		t.Fatal("opened a value moved to another name")
+	}
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := cs.open("a.paste", tampered); err == nil {
+		t.Fatal("opened a tampered value")
+	}
+	if _, err := cs.open("a.paste", sealed[:len(atRestMagic)+3]); err == nil {
+		t.Fatal("opened a truncated value")
+	}
+
+	// Plaintext from before encryption passes through
+	if got, err := cs.open("old.paste", []byte("plain")); err != nil || string(got) != "plain" {
+		t.Fatalf("plaintext: %q, %v", got, err)
+	}
+
+	if _, err := newCryptStore(cs.Store, [][]byte{[]byte("short")}); err == nil {
+		t.Fatal("accepted a short key")
+	}
+	if _, err := newCryptStore(cs.Store, nil); err == nil {
+		t.Fatal("accepted no keys")
+	}
+}
+
+func TestCryptRotation(t *testing.T) {
+	d := &dirStore{dir: t.TempDir()}
+	old := newTestCrypt(t, d, testKey(1))
+	if err := old.Put("a.paste", []byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	d.Put("b.paste", []byte("plain"))
+
+	cs := newTestCrypt(t, d, testKey(2), testKey(1))
+	if got, err := cs.Get("a.paste"); err != nil || string(got) != "one" {
+		t.Fatalf("old key: %q, %v", got, err)
+	}
+	raw, _ := d.Get("a.paste")
+	if cs.current(raw) {
+		t.Fatal("a value under the old key counts as current")
+	}
+
+	cs.Put("c.paste", []byte("three"))
+	raw, _ = d.Get("c.paste")
+	if !cs.current(raw) {
+		t.Fatal("a new value isn't sealed under the current key")
+	}
+
+	// Dropping a key leaves what it sealed unreadable
+	if _, err := newTestCrypt(t, d, testKey(2)).Get("a.paste"); err == nil || !strings.Contains(err.Error(), "unknown key") {
+		t.Fatalf("retired key: got %v, want an unknown key error", err)
+	}
+
+	cs.rewrap()
+	for name, want := range map[string]string{"a.paste": "one", "b.paste": "plain", "c.paste": "three"} {
+		raw, _ := d.Get(name)
+		if !cs.current(raw) {
+			t.Fatalf("%s not rewrapped", name)
+		}
+		if got, err := newTestCrypt(t, d, testKey(2)).Get(name); err != nil || string(got) != want {
+			t.Fatalf("%s under the new key alone: %q, %v", name, got, err)
+		}
+	}
+}
+
+// Rewrapping never undoes a write or a delete that came in after its read
+func TestCryptReseal(t *testing.T) {
+	d := &dirStore{dir: t.TempDir()}
+	cs := newTestCrypt(t, d, testKey(2), testKey(1))
+	d.Put("a.paste", []byte("stale"))
+	d.Put("b.paste", []byte("gone"))
+	d.Put("c.paste", []byte("same"))
+
+	cs.Put("a.paste", []byte("fresh"))
+	d.Delete("b.paste")
+
+	for _, tt := range []struct {
+		name, old string
+		want      bool
+	}{
+		{"a.paste", "stale", false},
+		{"b.paste", "gone", false},
+		{"c.paste", "same", true},
+	} {
+		done, err := cs.reseal(tt.name, []byte(tt.old), []byte(tt.old))
+		if err != nil || done != tt.want {
+			t.Fatalf("%s: resealed %v, %v; want %v", tt.name, done, err, tt.want)
+		}
+	}
+
+	if got, _ := cs.Get("a.paste"); string(got) != "fresh" {
+		t.Fatalf("a.paste: %q, want the write that raced the rewrap", got)
+	}
+	if _, err := d.Get("b.paste"); !os.IsNotExist(err) {
+		t.Fatalf("b.paste came back after its delete: %v", err)
+	}
+	if got, _ := cs.Get("c.paste"); string(got) != "same" {
+		t.Fatalf("c.paste: %q", got)
+	}
+}
+
+func TestCheckUnsealed(t *testing.T) {
+	d := &dirStore{dir: t.TempDir()}
+	d.Put("a.paste", []byte("plain"))
+	if err := checkUnsealed(d); err != nil {
+		t.Fatal(err)
+	}
+
+	newTestCrypt(t, d, testKey(1)).Put("b.meta", []byte("{}"))
+	if err := checkUnsealed(d); err == nil {
+		t.Fatal("opened a sealed store without its key")
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A single file in an uploaded bundle
+type bundleFile struct {
+	Name string
+	Body []byte
+}
+
+// Collect the file parts of a multipart upload, ordered by form field name
+func formFiles(form *multipart.Form) ([]bundleFile, error) {
+	if form == nil {
+		return nil, nil
+	}
+
+	fields := make([]string, 0, len(form.File))
+	for field := range form.File {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var files []bundleFile
+	seen := make(map[string]bool)
+	for _, field := range fields {
+		for _, fh := range form.File[field] {
+			name := path.Base(strings.Replace(fh.Filename, "\\", "/", -1))
+			if name == "" || name == "." || name == "/" || name == ".." {
+				name = field
+			}
+			if seen[name] {
+				return nil, httpErrorf(http.StatusBadRequest, "duplicate file name %q", name)
+			}
+			seen[name] = true
+
+			f, err := fh.Open()
+			if err != nil {
+				return nil, err
+			}
+			body, err := ioutil.ReadAll(f)
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+
+			files = append(files, bundleFile{name, body})
+		}
+	}
+
+	return files, nil
+}
+
+// Pack files into a tar stream — headers are fixed so equal bundles hash
+// equally, and PAX carries names that are long or not ASCII
+func packBundle(files []bundleFile) ([]byte, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	for _, f := range files {
+		hdr := &tar.Header{
+			Name:     f.Name,
+			Mode:     0644,
+			Size:     int64(len(f.Body)),
+			Typeflag: tar.TypeReg,
+			ModTime:  time.Unix(0, 0),
+			Format:   tar.FormatPAX,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(f.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unpack every file from a stored bundle
+func unpackBundle(data []byte) ([]bundleFile, error) {
+	var files []bundleFile
+	tr := tar.NewReader(bytes.NewReader(data))
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, bundleFile{hdr.Name, body})
+	}
+}
+
+// Look up a bundle by key, as openPaste does for plain pastes
+func openBundle(w http.ResponseWriter, r *http.Request, key string) ([]bundleFile, bool) {
+	m, data, ok := openPaste(w, r, key)
+	if !ok {
+		return nil, false
+	}
+	if !m.Bundle() {
+		http.Error(w, fmt.Sprintf("[%s] is not a bundle", key), http.StatusNotFound)
+		return nil, false
+	}
+
+	files, err := unpackBundle(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return files, true
+}
+
+// List a bundle's files as one URL per line
+func listBundle(w http.ResponseWriter, r *http.Request, m *Meta) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, name := range m.Files {
+		fmt.Fprintf(w, "%s%s/%s/%s\n", proto, r.Host, m.Key, url.PathEscape(name))
+	}
+}
+
+// Bundle file handler — serves one file out of a bundle
+func handleBundleFile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key, name := vars["pasteId"], vars["filename"]
+
+	files, ok := openBundle(w, r, key)
+	if !ok {
+		return
+	}
+
+	for _, f := range files {
+		if f.Name == name {
+			pastesViewed.inc()
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write(f.Body)
+			return
+		}
+	}
+
+	http.Error(w, fmt.Sprintf("[%s/%s] not found", key, name), http.StatusNotFound)
+}
+
+// Archive handler — downloads a whole bundle as .tar.gz or .zip
+func handleArchive(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key, ext := vars["pasteId"], vars["ext"]
+
+	files, ok := openBundle(w, r, key)
+	if !ok {
+		return
+	}
+
+	var buf bytes.Buffer
+	var err error
+	switch ext {
+	case "tar.gz":
+		w.Header().Set("Content-Type", "application/gzip")
+		err = writeTarGz(&buf, key, files)
+	case "zip":
+		w.Header().Set("Content-Type", "application/zip")
+		err = writeZip(&buf, key, files)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", key+"."+ext))
+	w.Write(buf.Bytes())
+}
+
+// Write files as a gzipped tarball rooted at dir/
+func writeTarGz(w io.Writer, dir string, files []bundleFile) error {
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+
+	for _, f := range files {
+		hdr := &tar.Header{
+			Name: dir + "/" + f.Name,
+			Mode: 0644,
+			Size: int64(len(f.Body)),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(f.Body); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Write files as a zip archive rooted at dir/
+func writeZip(w io.Writer, dir string, files []bundleFile) error {
+	zw := zip.NewWriter(w)
+
+	for _, f := range files {
+		fw, err := zw.Create(dir + "/" + f.Name)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(f.Body); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
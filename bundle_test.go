@@ -0,0 +1,147 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func readForm(t *testing.T, parts [][3]string) *multipart.Form {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, p := range parts {
+		fw, err := mw.CreateFormFile(p[0], p[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(p[2]))
+	}
+	mw.Close()
+
+	form, err := multipart.NewReader(&buf, mw.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return form
+}
+
+func fileNames(files []bundleFile) string {
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	return strings.Join(names, " ")
+}
+
+func TestFormFiles(t *testing.T) {
+	files, err := formFiles(readForm(t, [][3]string{
+		{"b", "dir/two.txt", "2"},
+		{"a", `C:\docs\one.txt`, "1"},
+		{"c", "..", "3"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fileNames(files); got != "one.txt two.txt c" {
+		t.Fatalf("names %q, want base names ordered by field", got)
+	}
+	if string(files[0].Body) != "1" || string(files[2].Body) != "3" {
+		t.Fatalf("bodies %q and %q", files[0].Body, files[2].Body)
+	}
+
+	_, err = formFiles(readForm(t, [][3]string{{"a", "x/same", "1"}, {"b", "y/same", "2"}}))
+	if err == nil || errorStatus(err).code != http.StatusBadRequest {
+		t.Fatalf("duplicate names: got %v, want a 400", err)
+	}
+}
+
+func TestPackBundle(t *testing.T) {
+	files := []bundleFile{
+		{"main.go", []byte("package main\n")},
+		{"empty", nil},
+		{"naïve-résumé.txt", []byte("unicode")},
+		{strings.Repeat("long", 40) + ".txt", []byte("long")},
+	}
+
+	data, err := packBundle(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := packBundle(files)
+	if !bytes.Equal(data, again) {
+		t.Fatal("packing the same files twice differs")
+	}
+
+	got, err := unpackBundle(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileNames(got) != fileNames(files) {
+		t.Fatalf("unpacked %q", fileNames(got))
+	}
+	for i := range files {
+		if !bytes.Equal(got[i].Body, files[i].Body) {
+			t.Fatalf("%s: %q, want %q", files[i].Name, got[i].Body, files[i].Body)
+		}
+	}
+
+	if _, err := unpackBundle([]byte("not a tarball, but long enough to hold a header or two")); err == nil {
+		t.Fatal("unpacked garbage")
+	}
+}
+
+func TestArchives(t *testing.T) {
+	files := []bundleFile{{"a.txt", []byte("one")}, {"b.txt", []byte("two")}}
+	want := "key/a.txt=one key/b.txt=two"
+
+	var buf bytes.Buffer
+	if err := writeTarGz(&buf, "key", files); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		body, _ := ioutil.ReadAll(tr)
+		got = append(got, hdr.Name+"="+string(body))
+	}
+	if strings.Join(got, " ") != want {
+		t.Fatalf("tar.gz holds %q, want %q", got, want)
+	}
+
+	buf.Reset()
+	if err := writeZip(&buf, "key", files); err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		got = append(got, f.Name+"="+string(body))
+	}
+	if strings.Join(got, " ") != want {
+		t.Fatalf("zip holds %q, want %q", got, want)
+	}
+}
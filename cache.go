@@ -0,0 +1,114 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// How long a cached blob is trusted — bounds how stale the cache gets when
+// another process, such as gopaste rm, changes the store underneath the server
+const cacheTTL = time.Minute
+
+// A size-bounded LRU cache of pastes and metadata in front of a store
+// Writes and deletes invalidate their name, so expiry and deletion take effect at once
+type cacheStore struct {
+	Store
+	sync.Mutex
+	max   int64
+	size  int64
+	gen   uint64 // bumped by every invalidation, so stale reads aren't cached
+	lru   *list.List
+	items map[string]*list.Element
+}
+
+type cacheItem struct {
+	name string
+	data []byte
+	at   time.Time
+}
+
+func newCacheStore(s Store, max int64) *cacheStore {
+	return &cacheStore{Store: s, max: max, lru: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (c *cacheStore) Get(name string) ([]byte, error) {
+	c.Lock()
+	if e, ok := c.items[name]; ok {
+		it := e.Value.(*cacheItem)
+		if time.Since(it.at) < cacheTTL {
+			c.lru.MoveToFront(e)
+			c.Unlock()
+			cacheHits.inc()
+			return it.data, nil
+		}
+		c.removeLocked(e)
+	}
+	gen := c.gen
+	c.Unlock()
+	cacheMisses.inc()
+
+	data, err := c.Store.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Lock()
+	if c.gen == gen {
+		c.addLocked(name, data)
+	}
+	c.Unlock()
+	return data, nil
+}
+
+func (c *cacheStore) Put(name string, data []byte) error {
+	c.invalidate(name)
+	err := c.Store.Put(name, data)
+	c.invalidate(name)
+	return err
+}
+
+func (c *cacheStore) Delete(name string) error {
+	c.invalidate(name)
+	err := c.Store.Delete(name)
+	c.invalidate(name)
+	return err
+}
+
+// Drop a name from the cache
+func (c *cacheStore) invalidate(name string) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.gen++
+	if e, ok := c.items[name]; ok {
+		c.removeLocked(e)
+	}
+}
+
+// Cache a blob, evicting the least recently used until it fits
+// Blobs over a quarter of the cache would churn it and are never kept
+func (c *cacheStore) addLocked(name string, data []byte) {
+	n := int64(len(data))
+	if n > c.max/4 {
+		return
+	}
+	if e, ok := c.items[name]; ok {
+		c.removeLocked(e)
+	}
+
+	for c.size+n > c.max && c.lru.Len() > 0 {
+		c.removeLocked(c.lru.Back())
+		cacheEvictions.inc()
+	}
+	c.items[name] = c.lru.PushFront(&cacheItem{name, data, time.Now()})
+	c.size += n
+	cacheBytes.set(float64(c.size))
+}
+
+func (c *cacheStore) removeLocked(e *list.Element) {
+	it := c.lru.Remove(e).(*cacheItem)
+	delete(c.items, it.name)
+	c.size -= int64(len(it.data))
+	cacheBytes.set(float64(c.size))
+}
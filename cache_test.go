@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A store counting reads that reach it, with a hook run mid-read
+type countingStore struct {
+	Store
+	gets   map[string]int
+	during func(name string)
+}
+
+func (s *countingStore) Get(name string) ([]byte, error) {
+	s.gets[name]++
+	data, err := s.Store.Get(name)
+	if s.during != nil {
+		s.during(name)
+	}
+	return data, err
+}
+
+func newTestCache(t *testing.T, max int64) (*cacheStore, *countingStore) {
+	cs := &countingStore{Store: &dirStore{dir: t.TempDir()}, gets: make(map[string]int)}
+	return newCacheStore(cs, max), cs
+}
+
+func TestCacheLRU(t *testing.T) {
+	c, s := newTestCache(t, 40)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		s.Put(name, bytes.Repeat([]byte(name), 10))
+	}
+
+	read := func(names string) {
+		t.Helper()
+		for _, name := range strings.Fields(names) {
+			if got, err := c.Get(name); err != nil || string(got) != strings.Repeat(name, 10) {
+				t.Fatalf("%s: %q, %v", name, got, err)
+			}
+		}
+	}
+	read("a b c d")
+	read("a b c d")
+	if s.gets["a"] != 1 || s.gets["d"] != 1 || c.size != 40 {
+		t.Fatalf("reads %v with %d bytes cached, want one each filling the cache", s.gets, c.size)
+	}
+
+	// a is freshest, so e pushes out b, the least recently used
+	read("a")
+	s.Put("e", bytes.Repeat([]byte("e"), 10))
+	read("e b")
+	if s.gets["b"] != 2 || s.gets["a"] != 1 {
+		t.Fatalf("reads %v, want b evicted and a kept", s.gets)
+	}
+	if c.size > c.max || int64(c.lru.Len()) != c.max/10 {
+		t.Fatalf("%d bytes in %d items cached, over the %d byte bound", c.size, c.lru.Len(), c.max)
+	}
+}
+
+func TestCacheSkipsLarge(t *testing.T) {
+	c, s := newTestCache(t, 40)
+	s.Put("big", make([]byte, 11))
+	s.Put("fits", make([]byte, 10))
+
+	c.Get("big")
+	c.Get("big")
+	c.Get("fits")
+	c.Get("fits")
+	if s.gets["big"] != 2 || s.gets["fits"] != 1 {
+		t.Fatalf("reads %v, want blobs over a quarter of the cache never kept", s.gets)
+	}
+}
+
+func TestCacheInvalidate(t *testing.T) {
+	c, s := newTestCache(t, 1<<10)
+	c.Put("a", []byte("one"))
+	c.Get("a")
+	c.Put("a", []byte("two"))
+	if got, _ := c.Get("a"); string(got) != "two" {
+		t.Fatalf("after overwrite read %q", got)
+	}
+
+	c.Delete("a")
+	if _, err := c.Get("a"); err == nil {
+		t.Fatal("read a deleted blob from the cache")
+	}
+
+	// A write landing while a read is in flight keeps that read's stale data out
+	c.Put("b", []byte("old"))
+	s.during = func(name string) {
+		s.during = nil
+		c.Put(name, []byte("new"))
+	}
+	if got, _ := c.Get("b"); string(got) != "old" {
+		t.Fatalf("racing read got %q", got)
+	}
+	if got, _ := c.Get("b"); string(got) != "new" {
+		t.Fatalf("after the race read %q, want the write, not the stale read", got)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	c, s := newTestCache(t, 1<<10)
+	s.Put("a", []byte("one"))
+	c.Get("a")
+
+	// Another process changes the store; the cache catches up once the entry ages out
+	s.Put("a", []byte("two"))
+	if got, _ := c.Get("a"); string(got) != "one" {
+		t.Fatalf("fresh entry read %q", got)
+	}
+	c.items["a"].Value.(*cacheItem).at = time.Now().Add(-cacheTTL)
+	if got, _ := c.Get("a"); string(got) != "two" {
+		t.Fatalf("expired entry read %q", got)
+	}
+	if s.gets["a"] != 2 {
+		t.Fatalf("%d reads reached the store, want 2", s.gets["a"])
+	}
+}
@@ -1,8 +1,22 @@
 #!/usr/bin/env bash
-# Clean up files older than $TIME days or so
+# Clean up pastes older than $TIME days or so
+# Deleting through gopaste keeps shared blobs' reference counts right
 
 TIME=31
-WEBROOT=/var/www/paste/pastes
+ROOT=/var/www/paste
+WEBROOT=$ROOT/pastes
 
-find $WEBROOT -type f -mtime +$TIME -name '*.paste' -execdir rm -- '{}' \;
+# The store flags the server runs with, so gopaste opens the store the same
+# way: -E and -K when encrypting at rest, -S shard:$WEBROOT/ when sharded
+FLAGS=""
 
+case "$FLAGS" in
+*db:* | *s3:*)
+	echo "cleanup.sh: only directory stores can be cleaned up; give pastes a lifetime with -x instead" >&2
+	exit 1
+	;;
+esac
+
+# Keys may start with "-", so end the flags before them
+find $WEBROOT -type f -mtime +$TIME \( -name '*.paste' -o -name '*.link' \) -printf '%f\n' |
+	sed 's/\.[a-z]*$//' | sort -u | xargs -r gopaste rm -r $ROOT $FLAGS --
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Client settings from ~/.config/gopaste/config, one "name = value" per line
+type clientConfig struct {
+	Server string
+	APIKey string
+}
+
+// Load the client config, with $GOPASTE_SERVER overriding the file
+func loadClientConfig() clientConfig {
+	var c clientConfig
+
+	if dir, err := os.UserConfigDir(); err == nil {
+		if f, err := os.Open(filepath.Join(dir, "gopaste", "config")); err == nil {
+			sc := bufio.NewScanner(f)
+			for sc.Scan() {
+				line := strings.TrimSpace(sc.Text())
+				if line == "" || line[0] == '#' {
+					continue
+				}
+				i := strings.IndexAny(line, "= \t")
+				if i < 0 {
+					continue
+				}
+				name, val := line[:i], strings.TrimSpace(strings.TrimLeft(line[i:], "= \t"))
+				switch name {
+				case "server":
+					c.Server = val
+				case "apikey":
+					c.APIKey = val
+				}
+			}
+			f.Close()
+		}
+	}
+
+	if s := os.Getenv("GOPASTE_SERVER"); s != "" {
+		c.Server = s
+	}
+	return c
+}
+
+// Whether rm's arguments name a URL, making it the client command
+func clientRm(args []string) bool {
+	for _, a := range args {
+		if strings.Contains(a, "://") {
+			return true
+		}
+	}
+	return false
+}
+
+// Turn a key into a URL on the configured server
+func pasteURL(server, arg string) (string, error) {
+	if strings.Contains(arg, "://") {
+		return arg, nil
+	}
+	if server == "" {
+		return "", errors.New("no server: pass -s, set $GOPASTE_SERVER or server in ~/.config/gopaste/config")
+	}
+	return strings.TrimRight(server, "/") + "/" + arg, nil
+}
+
+// Fail on a non-2xx response, showing the server's message
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+}
+
+// Client: paste files, or stdin, and print the URL
+func cmdPut(args []string) {
+	cfg := loadClientConfig()
+
+	fs := flag.NewFlagSet("put", flag.ExitOnError)
+	server := fs.String("s", cfg.Server, "Server URL")
+	apikey := fs.String("k", cfg.APIKey, "API key")
+	expire := fs.String("x", "", "Lifetime, e.g. 12h, 7d, 2w or never")
+	lang := fs.String("l", "", "Language of the paste")
+	burn := fs.Bool("b", false, "Delete the paste once it has been read")
+	vis := fs.String("V", "", "Visibility: public, unlisted or private")
+	pass := fs.String("p", "", "Password readers must give")
+	fs.StringVar(&formVal, "v", "paste", "Form value that appears in 'paste=<-' style form values")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gopaste put [flags] [file...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *server == "" {
+		fatal(errors.New("no server: pass -s, set $GOPASTE_SERVER or server in ~/.config/gopaste/config"))
+	}
+
+	opts := map[string]string{
+		"expire":     *expire,
+		"lang":       *lang,
+		"visibility": *vis,
+		"password":   *pass,
+		"apikey":     *apikey,
+		"token":      "1",
+	}
+	if *burn {
+		opts["burn"] = "1"
+	}
+
+	// Stream the form so large pastes never sit in memory
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writePutForm(mw, fs.Args(), opts))
+	}()
+
+	resp, err := http.Post(*server, mw.FormDataContentType(), pr)
+	if err != nil {
+		fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		fatal(err)
+	}
+
+	u, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		fatal(err)
+	}
+	u = bytes.TrimSpace(u)
+	fmt.Printf("%s\n", u)
+	if t := resp.Header.Get("X-Delete-Token"); t != "" {
+		fmt.Fprintf(os.Stderr, "delete with: gopaste rm %s %s\n", u, t)
+	}
+}
+
+// Write the upload form: options, then stdin, one file, or a bundle of files
+func writePutForm(mw *multipart.Writer, files []string, opts map[string]string) error {
+	for name, val := range opts {
+		if val == "" {
+			continue
+		}
+		if err := mw.WriteField(name, val); err != nil {
+			return err
+		}
+	}
+
+	if len(files) <= 1 {
+		in := io.Reader(os.Stdin)
+		if len(files) == 1 {
+			f, err := os.Open(files[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			in = f
+		}
+
+		w, err := mw.CreateFormField(formVal)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, in); err != nil {
+			return err
+		}
+		return mw.Close()
+	}
+
+	// Field names sort in argument order, which the server keeps
+	for i, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		w, err := mw.CreateFormFile(fmt.Sprintf("f%04d", i), filepath.Base(name))
+		if err == nil {
+			_, err = io.Copy(w, f)
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// Client: print a paste by URL or key, decrypting it if the URL carries a key
+func cmdGet(args []string) {
+	cfg := loadClientConfig()
+
+	fs := flag.NewFlagSet("get", flag.ExitOnError)
+	server := fs.String("s", cfg.Server, "Server URL, for bare keys")
+	pass := fs.String("p", "", "Password of a protected paste")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gopaste get [-s server] [-p password] url|key")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	u, err := pasteURL(*server, fs.Arg(0))
+	if err != nil {
+		fatal(err)
+	}
+	var key string
+	if i := strings.LastIndex(u, "#"); i >= 0 {
+		u, key = u[:i], u[i+1:]
+	}
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		fatal(err)
+	}
+	if *pass != "" {
+		req.SetBasicAuth("", *pass)
+	}
+	if cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.APIKey)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		fatal(err)
+	}
+
+	if key == "" {
+		if _, err := io.Copy(os.Stdout, resp.Body); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		fatal(err)
+	}
+	plain, err := decryptPaste(string(body), key)
+	if err != nil {
+		fatal(err)
+	}
+	os.Stdout.Write(plain)
+}
+
+// Client: delete a paste with its delete token
+func cmdDelete(args []string) {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: gopaste rm url token")
+		os.Exit(2)
+	}
+
+	u := args[0]
+	if i := strings.LastIndex(u, "#"); i >= 0 {
+		u = u[:i]
+	}
+
+	req, err := http.NewRequest("DELETE", u, nil)
+	if err != nil {
+		fatal(err)
+	}
+	req.Header.Set("X-Delete-Token", args[1])
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		fatal(err)
+	}
+	io.Copy(os.Stdout, resp.Body)
+}
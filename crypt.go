@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Prefix on client-encrypted paste bodies, followed by base64(nonce + sealed)
+const encPrefix = "gpenc1:"
+
+// Seal plaintext under a fresh random key, returning the paste body and URL-safe key
+func encryptPaste(plain []byte) (string, string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", "", err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, plain, nil)
+	body := encPrefix + base64.StdEncoding.EncodeToString(sealed)
+	return body, base64.RawURLEncoding.EncodeToString(key), nil
+}
+
+// Open a paste body produced by encryptPaste
+func decryptPaste(body, key string) ([]byte, error) {
+	body = strings.TrimSpace(body)
+	if !strings.HasPrefix(body, encPrefix) {
+		return nil, errors.New("paste is not encrypted")
+	}
+
+	k, err := base64.RawURLEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("bad key: %v", err)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(body[len(encPrefix):])
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(k)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("paste too short")
+	}
+
+	n := gcm.NonceSize()
+	return gcm.Open(nil, sealed[:n], sealed[n:], nil)
+}
+
+// AES-256-GCM for a raw key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Client: encrypt stdin, upload it and print the URL with the key as fragment
+func cmdEncrypt(args []string) {
+	cfg := loadClientConfig()
+
+	fs := flag.NewFlagSet("encrypt", flag.ExitOnError)
+	apikey := fs.String("k", cfg.APIKey, "API key")
+	fs.StringVar(&formVal, "v", "paste", "Form value that appears in 'paste=<-' style form values")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gopaste encrypt [-k apikey] [-v formval] url < file")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	plain, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		fatal(err)
+	}
+	body, key, err := encryptPaste(plain)
+	if err != nil {
+		fatal(err)
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if *apikey != "" {
+		mw.WriteField("apikey", *apikey)
+	}
+	mw.WriteField(formVal, body)
+	mw.WriteField("encrypted", "1")
+	mw.Close()
+
+	resp, err := http.Post(fs.Arg(0), mw.FormDataContentType(), &buf)
+	if err != nil {
+		fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		fatal(err)
+	}
+
+	u, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		fatal(err)
+	}
+
+	fmt.Printf("%s#%s\n", bytes.TrimSpace(u), key)
+}
+
+// Client: fetch an encrypted paste by URL#key and print the plaintext
+func cmdDecrypt(args []string) {
+	if len(args) != 1 || !strings.Contains(args[0], "#") {
+		fmt.Fprintln(os.Stderr, "usage: gopaste decrypt url#key")
+		os.Exit(2)
+	}
+
+	i := strings.LastIndex(args[0], "#")
+	u, key := args[0][:i], args[0][i+1:]
+
+	resp, err := http.Get(u)
+	if err != nil {
+		fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		fatal(fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body)))
+	}
+
+	plain, err := decryptPaste(string(body), key)
+	if err != nil {
+		fatal(err)
+	}
+	os.Stdout.Write(plain)
+}
+
+// Print an error and exit — for command line modes
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "gopaste: %v\n", err)
+	os.Exit(1)
+}
+
+// Decryption page for browsers — the key never leaves the URL fragment
+var decryptTmpl = template.Must(template.New("decrypt").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Key}}</title>
+<style>
+body { margin: 1em; }
+pre { white-space: pre-wrap; word-wrap: break-word; }
+</style>
+</head>
+<body>
+<pre id="out">Decrypting…</pre>
+<pre id="ct" hidden>{{.Body}}</pre>
+<script>
+(function() {
+	var out = document.getElementById("out");
+	function b64(s) {
+		s = s.replace(/-/g, "+").replace(/_/g, "/");
+		while (s.length % 4) s += "=";
+		return Uint8Array.from(atob(s), function(c) { return c.charCodeAt(0); });
+	}
+	var key = location.hash.slice(1);
+	var body = document.getElementById("ct").textContent.trim();
+	if (!key) { out.textContent = "Missing key: the URL must end in #<key>."; return; }
+	if (body.indexOf("{{.Prefix}}") !== 0) { out.textContent = "Paste is not encrypted."; return; }
+	var sealed = b64(body.slice({{.PrefixLen}}));
+	crypto.subtle.importKey("raw", b64(key), "AES-GCM", false, ["decrypt"]).then(function(k) {
+		return crypto.subtle.decrypt({name: "AES-GCM", iv: sealed.slice(0, 12)}, k, sealed.slice(12));
+	}).then(function(plain) {
+		out.textContent = new TextDecoder().decode(plain);
+	}, function() {
+		out.textContent = "Decryption failed: wrong key or corrupted paste.";
+	});
+})();
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+)
+
+// A single-file store: an append-only log of puts and deletes with an
+// in-memory index of where each name's latest data lives.
+//
+// The file starts with dbMagic, then holds records of
+//
+//	crc32 (4) | op (1) | name length (2) | data length (4) | name | data
+//
+// all big-endian, the checksum covering everything after itself. A record is
+// written and synced before the index changes, so every update is atomic: a
+// crash leaves at most a torn record at the end, which the next open drops.
+// A bad record anywhere else is corruption, and the file is refused.
+// Space held by overwritten and deleted data is reclaimed in the background
+// by compacting into a new file that replaces the old one.
+type dbStore struct {
+	sync.RWMutex
+	path       string
+	f          *os.File
+	size       int64              // end of the log
+	index      map[string]dbEntry // name → latest data
+	garbage    int64              // bytes of records no longer live
+	compacting bool               // a compaction is pending or running
+}
+
+// Where a name's data sits in the file
+type dbEntry struct {
+	off    int64 // of the record
+	length int64 // of the data
+}
+
+const (
+	dbMagic  = "GPDB1\n"
+	dbHeader = 4 + 1 + 2 + 4
+
+	dbPut    = 1
+	dbDelete = 2
+
+	// Compact once this much is garbage and garbage outweighs live data
+	dbCompactMin = 1 << 20
+)
+
+// Open or create a database file, replaying its log
+func openDBStore(path string) (*dbStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: in use by another process: %v", path, err)
+	}
+
+	s := &dbStore{path: path, f: f, index: make(map[string]dbEntry)}
+	if err := s.load(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return s, nil
+}
+
+// Rebuild the index from the log, cutting off a torn record at the end
+func (s *dbStore) load() error {
+	fi, err := s.f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if fi.Size() == 0 {
+		if _, err := s.f.WriteAt([]byte(dbMagic), 0); err != nil {
+			return err
+		}
+		s.size = int64(len(dbMagic))
+		return s.f.Sync()
+	}
+
+	magic := make([]byte, len(dbMagic))
+	if _, err := s.f.ReadAt(magic, 0); err != nil || string(magic) != dbMagic {
+		return errors.New("not a gopaste database")
+	}
+
+	off := int64(len(dbMagic))
+	for off < fi.Size() {
+		op, name, length, err := s.readRecord(off, fi.Size())
+		if err != nil {
+			if !s.lastRecord(off, fi.Size()) {
+				return fmt.Errorf("corrupt record at offset %d: %v", off, err)
+			}
+			break
+		}
+
+		if old, ok := s.index[name]; ok {
+			s.garbage += dbHeader + int64(len(name)) + old.length
+		}
+		n := dbHeader + int64(len(name)) + length
+		if op == dbPut {
+			s.index[name] = dbEntry{off, length}
+		} else {
+			delete(s.index, name)
+			s.garbage += n
+		}
+		off += n
+	}
+
+	if off < fi.Size() {
+		if err := s.f.Truncate(off); err != nil {
+			return err
+		}
+	}
+	s.size = off
+	return nil
+}
+
+// Whether the record at off reaches the end of the file — by its length as
+// far as its header can be read — and so may be what a crash tore
+func (s *dbStore) lastRecord(off, end int64) bool {
+	var hdr [dbHeader]byte
+	if off+dbHeader > end {
+		return true
+	}
+	if _, err := s.f.ReadAt(hdr[:], off); err != nil {
+		return false
+	}
+	nlen := int64(binary.BigEndian.Uint16(hdr[5:]))
+	dlen := int64(binary.BigEndian.Uint32(hdr[7:]))
+	return off+dbHeader+nlen+dlen >= end
+}
+
+// Read and check the record at off, which must end by end, returning its op, name and data length
+func (s *dbStore) readRecord(off, end int64) (byte, string, int64, error) {
+	var hdr [dbHeader]byte
+	if _, err := s.f.ReadAt(hdr[:], off); err != nil {
+		return 0, "", 0, err
+	}
+	op := hdr[4]
+	nlen := int64(binary.BigEndian.Uint16(hdr[5:]))
+	dlen := int64(binary.BigEndian.Uint32(hdr[7:]))
+	if op != dbPut && op != dbDelete || off+dbHeader+nlen+dlen > end {
+		return 0, "", 0, errors.New("bad record")
+	}
+
+	body := make([]byte, nlen+dlen)
+	if _, err := s.f.ReadAt(body, off+dbHeader); err != nil {
+		return 0, "", 0, err
+	}
+	crc := crc32.NewIEEE()
+	crc.Write(hdr[4:])
+	crc.Write(body)
+	if crc.Sum32() != binary.BigEndian.Uint32(hdr[:4]) {
+		return 0, "", 0, errors.New("bad checksum")
+	}
+	return op, string(body[:nlen]), dlen, nil
+}
+
+// Encode one record
+func dbRecord(op byte, name string, data []byte) []byte {
+	rec := make([]byte, dbHeader+len(name)+len(data))
+	rec[4] = op
+	binary.BigEndian.PutUint16(rec[5:], uint16(len(name)))
+	binary.BigEndian.PutUint32(rec[7:], uint32(len(data)))
+	copy(rec[dbHeader:], name)
+	copy(rec[dbHeader+len(name):], data)
+	binary.BigEndian.PutUint32(rec, crc32.ChecksumIEEE(rec[4:]))
+	return rec
+}
+
+// Append a record and sync it, returning where it went
+func (s *dbStore) append(rec []byte) (int64, error) {
+	off := s.size
+	if _, err := s.f.WriteAt(rec, off); err != nil {
+		s.f.Truncate(off)
+		return 0, err
+	}
+	if err := s.f.Sync(); err != nil {
+		return 0, err
+	}
+	s.size += int64(len(rec))
+	return off, nil
+}
+
+func (s *dbStore) Get(name string) ([]byte, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	e, ok := s.index[name]
+	if !ok {
+		return nil, &os.PathError{Op: "get", Path: name, Err: os.ErrNotExist}
+	}
+	data := make([]byte, e.length)
+	_, err := s.f.ReadAt(data, e.off+dbHeader+int64(len(name)))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return data, err
+}
+
+func (s *dbStore) Put(name string, data []byte) error {
+	if len(name) > 0xffff || int64(len(data)) > 0xffffffff {
+		return fmt.Errorf("%s: too large for the database", name)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	off, err := s.append(dbRecord(dbPut, name, data))
+	if err != nil {
+		return err
+	}
+	if old, ok := s.index[name]; ok {
+		s.garbage += dbHeader + int64(len(name)) + old.length
+	}
+	s.index[name] = dbEntry{off, int64(len(data))}
+	s.maybeCompact()
+	return nil
+}
+
+func (s *dbStore) Delete(name string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	old, ok := s.index[name]
+	if !ok {
+		return &os.PathError{Op: "delete", Path: name, Err: os.ErrNotExist}
+	}
+	rec := dbRecord(dbDelete, name, nil)
+	if _, err := s.append(rec); err != nil {
+		return err
+	}
+	delete(s.index, name)
+	s.garbage += 2*dbHeader + 2*int64(len(name)) + old.length
+	s.maybeCompact()
+	return nil
+}
+
+// Names in the order they were last written, oldest first
+func (s *dbStore) List() ([]string, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	names := make([]string, 0, len(s.index))
+	for name := range s.index {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return s.index[names[i]].off < s.index[names[j]].off })
+	return names, nil
+}
+
+// Start compacting if enough of the file is garbage — called with the lock
+// held, by a write that has already succeeded whatever becomes of this
+func (s *dbStore) maybeCompact() {
+	if s.compacting || s.garbage < dbCompactMin || s.garbage < s.size-s.garbage {
+		return
+	}
+	s.compacting = true
+
+	go func() {
+		s.Lock()
+		defer s.Unlock()
+		if err := s.compact(); err != nil {
+			log.Printf("%s: compacting: %v", s.path, err)
+		}
+		s.compacting = false
+	}()
+}
+
+// Rewrite the live records, oldest first, into a new file and swap it in
+func (s *dbStore) compact() error {
+	tmp := s.path + ".compact"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	// Lock before the rename so the path is never unlocked
+	if err := lockFile(f); err != nil {
+		return fail(err)
+	}
+
+	names := make([]string, 0, len(s.index))
+	for name := range s.index {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return s.index[names[i]].off < s.index[names[j]].off })
+
+	index := make(map[string]dbEntry, len(s.index))
+	off := int64(len(dbMagic))
+	if _, err := f.WriteAt([]byte(dbMagic), 0); err != nil {
+		return fail(err)
+	}
+	for _, name := range names {
+		e := s.index[name]
+		rec := make([]byte, dbHeader+int64(len(name))+e.length)
+		if _, err := s.f.ReadAt(rec, e.off); err != nil {
+			return fail(err)
+		}
+		if _, err := f.WriteAt(rec, off); err != nil {
+			return fail(err)
+		}
+		index[name] = dbEntry{off, e.length}
+		off += int64(len(rec))
+	}
+	if err := f.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fail(err)
+	}
+
+	s.f.Close()
+	s.f, s.index, s.size, s.garbage = f, index, off, 0
+	return nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openTestDB(t *testing.T, path string) *dbStore {
+	s, err := openDBStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		waitCompact(s)
+		s.f.Close()
+	})
+	return s
+}
+
+// Wait out a compaction started in the background
+func waitCompact(s *dbStore) {
+	for {
+		s.RLock()
+		busy := s.compacting
+		s.RUnlock()
+		if !busy {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDBStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gp.db")
+	s := openTestDB(t, path)
+
+	if _, err := s.Get("a.paste"); !os.IsNotExist(err) {
+		t.Fatalf("get missing: got %v, want a not-exist error", err)
+	}
+	if err := s.Delete("a.paste"); !os.IsNotExist(err) {
+		t.Fatalf("delete missing: got %v, want a not-exist error", err)
+	}
+
+	for _, kv := range [][2]string{{"a.paste", "one"}, {"b.paste", "two"}, {"a.paste", "three"}, {"c.paste", ""}} {
+		if err := s.Put(kv[0], []byte(kv[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Delete("b.paste"); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(s *dbStore) {
+		t.Helper()
+		if got, err := s.Get("a.paste"); err != nil || string(got) != "three" {
+			t.Fatalf("a.paste: %q, %v", got, err)
+		}
+		if got, err := s.Get("c.paste"); err != nil || len(got) != 0 {
+			t.Fatalf("c.paste: %q, %v", got, err)
+		}
+		if _, err := s.Get("b.paste"); !os.IsNotExist(err) {
+			t.Fatalf("b.paste: got %v, want a not-exist error", err)
+		}
+		names, _ := s.List()
+		if strings.Join(names, " ") != "a.paste c.paste" {
+			t.Fatalf("listed %q, want oldest write first", names)
+		}
+	}
+	check(s)
+
+	if _, err := openDBStore(path); err == nil {
+		t.Fatal("opened a database another store holds")
+	}
+
+	s.f.Close()
+	check(openTestDB(t, path))
+}
+
+// A record cut short or corrupted at the end of the log is dropped on open,
+// and the next write goes where it was
+func TestDBStoreTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gp.db")
+	s := openTestDB(t, path)
+	if err := s.Put("a.paste", []byte("kept")); err != nil {
+		t.Fatal(err)
+	}
+	good := s.size
+	s.f.Close()
+
+	rec := dbRecord(dbPut, "b.paste", []byte("torn"))
+	bad := append([]byte(nil), rec...)
+	bad[len(bad)-1] ^= 0xff
+
+	for name, tail := range map[string][]byte{
+		"partial header": rec[:5],
+		"partial data":   rec[:len(rec)-2],
+		"bad checksum":   bad,
+		"bad op":         append([]byte{0, 0, 0, 0, 9}, rec[5:]...),
+	} {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(tail)
+		f.Close()
+
+		s := openTestDB(t, path)
+		if fi, _ := os.Stat(path); fi.Size() != good {
+			t.Fatalf("%s: file is %d bytes after open, want %d", name, fi.Size(), good)
+		}
+		if got, err := s.Get("a.paste"); err != nil || string(got) != "kept" {
+			t.Fatalf("%s: a.paste: %q, %v", name, got, err)
+		}
+		if _, err := s.Get("b.paste"); !os.IsNotExist(err) {
+			t.Fatalf("%s: torn b.paste: got %v, want a not-exist error", name, err)
+		}
+		s.f.Close()
+	}
+
+	s = openTestDB(t, path)
+	if err := s.Put("b.paste", []byte("whole")); err != nil {
+		t.Fatal(err)
+	}
+	s.f.Close()
+	if got, err := openTestDB(t, path).Get("b.paste"); err != nil || string(got) != "whole" {
+		t.Fatalf("b.paste after recovery: %q, %v", got, err)
+	}
+}
+
+// A bad record with good ones after it wasn't torn by a crash — the
+// database is refused rather than cut back to it
+func TestDBStoreCorruptRecord(t *testing.T) {
+	for name, corrupt := range map[string]func(rec []byte){
+		"bad checksum": func(rec []byte) { rec[len(rec)-1] ^= 0xff },
+		"bad op":       func(rec []byte) { rec[4] = 9 },
+		"bad length":   func(rec []byte) { rec[10]++ },
+	} {
+		path := filepath.Join(t.TempDir(), "gp.db")
+		s := openTestDB(t, path)
+		s.Put("a.paste", []byte("one"))
+		mid := s.size
+		s.Put("b.paste", []byte("two"))
+		s.Put("c.paste", []byte("three"))
+		s.f.Close()
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		corrupt(data[mid : mid+int64(len(dbRecord(dbPut, "b.paste", []byte("two"))))])
+		ioutil.WriteFile(path, data, 0600)
+
+		_, err = openDBStore(path)
+		if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("offset %d", mid)) {
+			t.Fatalf("%s: got %v, want an error at offset %d", name, err, mid)
+		}
+		if after, _ := ioutil.ReadFile(path); !bytes.Equal(after, data) {
+			t.Fatalf("%s: refused database was changed", name)
+		}
+	}
+}
+
+func TestDBStoreCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gp.db")
+	s := openTestDB(t, path)
+
+	big := bytes.Repeat([]byte("x"), 64<<10)
+	for i := 0; i < 40; i++ {
+		if err := s.Put("big.paste", append(big, byte(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Put("small.paste", []byte("small")); err != nil {
+		t.Fatal(err)
+	}
+	waitCompact(s)
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() > 2*dbCompactMin || s.garbage >= dbCompactMin {
+		t.Fatalf("file is %d bytes with %d garbage: never compacted", fi.Size(), s.garbage)
+	}
+	if fi.Size() != s.size {
+		t.Fatalf("file is %d bytes, store thinks %d", fi.Size(), s.size)
+	}
+	if _, err := os.Stat(path + ".compact"); !os.IsNotExist(err) {
+		t.Fatalf("compaction left its temporary file: %v", err)
+	}
+
+	check := func(s *dbStore) {
+		t.Helper()
+		if got, err := s.Get("big.paste"); err != nil || !bytes.Equal(got, append(big, 39)) {
+			t.Fatalf("big.paste: %d bytes, %v", len(got), err)
+		}
+		if got, err := s.Get("small.paste"); err != nil || string(got) != "small" {
+			t.Fatalf("small.paste: %q, %v", got, err)
+		}
+	}
+	check(s)
+
+	// The compacted file is what's locked now, and replays the same
+	if _, err := openDBStore(path); err == nil {
+		t.Fatal("opened a compacted database another store holds")
+	}
+	s.f.Close()
+	check(openTestDB(t, path))
+}
+
+func TestMetaIndex(t *testing.T) {
+	s := openTestDB(t, filepath.Join(t.TempDir(), "gp.db"))
+	now := time.Now()
+	put := func(st Store, m *Meta) {
+		t.Helper()
+		b, _ := json.Marshal(m)
+		if err := st.Put(m.Key+".meta", b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Metadata already stored is indexed on open
+	put(s, &Meta{Key: "old", Created: now.Add(-3 * time.Hour)})
+	ix, err := newMetaIndex(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	put(ix, &Meta{Key: "new", Created: now, Expires: now.Add(time.Hour)})
+	put(ix, &Meta{Key: "mid", Created: now.Add(-time.Hour), Expires: now.Add(-time.Minute)})
+	put(ix, &Meta{Key: "gone", Created: now.Add(-2 * time.Hour), Expires: now.Add(-2 * time.Minute)})
+	put(ix, &Meta{Key: "public", Created: now.Add(-4 * time.Hour), Visibility: Public})
+	if err := ix.Delete("public.meta"); err != nil {
+		t.Fatal(err)
+	}
+	put(ix, &Meta{Key: "new", Created: now, Expires: now.Add(2 * time.Hour)})
+
+	keys := func(metas []*Meta) string {
+		var k []string
+		for _, m := range metas {
+			k = append(k, m.Key)
+		}
+		return strings.Join(k, " ")
+	}
+	if got := keys(ix.list(func(*Meta) bool { return true })); got != "new old" {
+		t.Fatalf("listed %q, want unexpired pastes newest first", got)
+	}
+	if got := keys(ix.list(func(m *Meta) bool { return m.Vis() == Public })); got != "" {
+		t.Fatalf("listed %q after deleting the public paste", got)
+	}
+	if got := strings.Join(ix.expired(), " "); got != "gone mid" {
+		t.Fatalf("expired %q, want soonest expiry first", got)
+	}
+	if len(ix.expires) != 3 {
+		t.Fatalf("%d pastes indexed by expiry, want 3 after rewriting one", len(ix.expires))
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Content-addressed paste storage: each key's <key>.paste is kept as a
+// <key>.link naming a <sum>.blob, so identical uploads share one blob
+// whatever their keys. <sum>.refs counts the links to a blob, which is
+// deleted with the last of them. Pastes stored before blobs existed are still
+// read, listed and deleted as plain <key>.paste files.
+//
+// The sum is the content's SHA-256, or under encryption at rest an HMAC keyed
+// from the at-rest key, so blob names don't confirm guesses at what's sealed.
+//
+// Writes order their steps so a crash can only leak a blob, never leave a
+// link to a missing one; gopaste verify reports the leaks. Every write holds
+// the store lock, which in a directory store is also a flock on its .lock
+// file, so a server and gopaste rm sharing the store never lose a count.
+// Stores other processes may change without such a lock can't hold blobs.
+type dedupStore struct {
+	Store
+	mu    sync.Mutex
+	lockf *os.File // nil when only this process uses the store
+	keys  [][]byte // naming blobs, current first; none for plain SHA-256
+}
+
+// The running blob layer, for verify's reference count audit
+var blobs *dedupStore
+
+// Take the store lock, waiting for other processes holding it
+func (s *dedupStore) Lock() {
+	s.mu.Lock()
+	if s.lockf != nil {
+		if err := waitLockFile(s.lockf); err != nil {
+			log.Printf("locking store: %v", err)
+		}
+	}
+}
+
+func (s *dedupStore) Unlock() {
+	if s.lockf != nil {
+		unlockFile(s.lockf)
+	}
+	s.mu.Unlock()
+}
+
+// Derive blob naming keys from at-rest keys, keeping them apart from the
+// keys that seal
+func blobKeys(raw [][]byte) [][]byte {
+	var keys [][]byte
+	for _, k := range raw {
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte("gopaste blob names"))
+		keys = append(keys, mac.Sum(nil))
+	}
+	return keys
+}
+
+// Name of the blob holding data under a naming key, or SHA-256 for none
+func blobSum(key, data []byte) string {
+	if key == nil {
+		return sha256Hex(data)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Name of the blob new data goes in
+func (s *dedupStore) sum(data []byte) string {
+	if len(s.keys) == 0 {
+		return blobSum(nil, data)
+	}
+	return blobSum(s.keys[0], data)
+}
+
+// Whether a blob's name fits its content, under any key it may have been
+// named with — blobs from before encryption or a rotation included
+func (s *dedupStore) sumMatches(sum string, data []byte) bool {
+	if blobSum(nil, data) == sum {
+		return true
+	}
+	for _, k := range s.keys {
+		if blobSum(k, data) == sum {
+			return true
+		}
+	}
+	return false
+}
+
+// Key of a paste content name
+func contentKey(name string) (string, bool) {
+	if !strings.HasSuffix(name, ".paste") {
+		return "", false
+	}
+	return strings.TrimSuffix(name, ".paste"), true
+}
+
+func (s *dedupStore) Get(name string) ([]byte, error) {
+	key, ok := contentKey(name)
+	if !ok {
+		return s.Store.Get(name)
+	}
+
+	link, err := s.Store.Get(key + ".link")
+	if os.IsNotExist(err) {
+		return s.Store.Get(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.Store.Get(string(link) + ".blob")
+}
+
+func (s *dedupStore) Put(name string, data []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	key, ok := contentKey(name)
+	if !ok {
+		return s.Store.Put(name, data)
+	}
+	sum := s.sum(data)
+
+	old, err := s.Store.Get(key + ".link")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if string(old) == sum {
+		return nil
+	}
+
+	if err := s.incRef(sum, data); err != nil {
+		return err
+	}
+	if err := s.Store.Put(key+".link", []byte(sum)); err != nil {
+		return err
+	}
+	if len(old) > 0 {
+		if err := s.decRef(string(old)); err != nil {
+			return err
+		}
+	}
+
+	// The pre-blob copy, if any, is superseded
+	if err := s.Store.Delete(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *dedupStore) Delete(name string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	key, ok := contentKey(name)
+	if !ok {
+		return s.Store.Delete(name)
+	}
+
+	link, err := s.Store.Get(key + ".link")
+	if os.IsNotExist(err) {
+		return s.Store.Delete(name)
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.Store.Delete(key + ".link"); err != nil {
+		return err
+	}
+	return s.decRef(string(link))
+}
+
+// Paste and metadata names, with links standing for their pastes
+func (s *dedupStore) List() ([]string, error) {
+	names, err := s.Store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	out := names[:0]
+	for _, name := range names {
+		switch {
+		case strings.HasSuffix(name, ".link"):
+			out = append(out, strings.TrimSuffix(name, ".link")+".paste")
+		case strings.HasSuffix(name, ".blob"), strings.HasSuffix(name, ".refs"):
+		default:
+			out = append(out, name)
+		}
+	}
+	return out, nil
+}
+
+// A blob's reference count, 0 when it has none
+func (s *dedupStore) refs(sum string) (int, error) {
+	b, err := s.Store.Get(sum + ".refs")
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
+
+// Add a reference to a blob, storing it if it is new — called with the lock held
+func (s *dedupStore) incRef(sum string, data []byte) error {
+	n, err := s.refs(sum)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		if err := s.Store.Put(sum+".blob", data); err != nil {
+			return err
+		}
+	}
+	return s.Store.Put(sum+".refs", []byte(strconv.Itoa(n+1)))
+}
+
+// Drop a reference to a blob, deleting it with the last — called with the lock held
+func (s *dedupStore) decRef(sum string) error {
+	n, err := s.refs(sum)
+	if err != nil {
+		return err
+	}
+	if n > 1 {
+		return s.Store.Put(sum+".refs", []byte(strconv.Itoa(n-1)))
+	}
+
+	if err := s.Store.Delete(sum + ".refs"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := s.Store.Delete(sum + ".blob"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Check every reference count against the links, describing each problem
+func (s *dedupStore) audit() ([]string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	names, err := s.Store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	have := make(map[string]bool)
+	links := make(map[string]int)
+	for _, name := range names {
+		have[name] = true
+	}
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".link") {
+			continue
+		}
+		sum, err := s.Store.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		links[string(sum)]++
+		if !have[string(sum)+".blob"] {
+			problems = append(problems, fmt.Sprintf("%s: links to missing blob %s", strings.TrimSuffix(name, ".link"), sum))
+		}
+	}
+
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".blob") {
+			continue
+		}
+		sum := strings.TrimSuffix(name, ".blob")
+		n, err := s.refs(sum)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("blob %s: bad reference count: %v", sum, err))
+			continue
+		}
+		if n != links[sum] {
+			problems = append(problems, fmt.Sprintf("blob %s: %d references counted, %d links", sum, n, links[sum]))
+		}
+
+		data, err := s.Store.Get(name)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("blob %s: %v", sum, err))
+		} else if !s.sumMatches(sum, data) {
+			problems = append(problems, fmt.Sprintf("blob %s: content hashes to %s", sum, s.sum(data)))
+		}
+	}
+	sort.Strings(problems)
+	return problems, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newTestDedup(t *testing.T, dir string) *dedupStore {
+	d := &dirStore{dir: dir}
+	s := &dedupStore{Store: d, lockf: openStoreLock(d)}
+	if s.lockf == nil {
+		t.Fatal("no lock file")
+	}
+	t.Cleanup(func() { s.lockf.Close() })
+	return s
+}
+
+func TestDedupRefs(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestDedup(t, dir)
+	one, two := sha256Hex([]byte("one")), sha256Hex([]byte("two"))
+
+	refs := func(sum string) int {
+		t.Helper()
+		n, err := s.refs(sum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(dir, name))
+		return err == nil
+	}
+	steps := []struct {
+		do       func() error
+		one, two int
+	}{
+		{func() error { return s.Put("a.paste", []byte("one")) }, 1, 0},
+		{func() error { return s.Put("b.paste", []byte("one")) }, 2, 0},
+		{func() error { return s.Put("a.paste", []byte("one")) }, 2, 0}, // unchanged
+		{func() error { return s.Put("a.paste", []byte("two")) }, 1, 1}, // overwritten
+		{func() error { return s.Delete("b.paste") }, 0, 1},
+		{func() error { return s.Put("b.paste", []byte("two")) }, 0, 2},
+		{func() error { return s.Delete("a.paste") }, 0, 1},
+		{func() error { return s.Delete("b.paste") }, 0, 0},
+	}
+	for i, st := range steps {
+		if err := st.do(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if refs(one) != st.one || refs(two) != st.two {
+			t.Fatalf("step %d: counts %d and %d, want %d and %d", i, refs(one), refs(two), st.one, st.two)
+		}
+		if exists(one+".blob") != (st.one > 0) || exists(two+".blob") != (st.two > 0) {
+			t.Fatalf("step %d: blobs left or deleted with counts %d and %d", i, st.one, st.two)
+		}
+		if problems, err := s.audit(); err != nil || len(problems) > 0 {
+			t.Fatalf("step %d: audit: %q, %v", i, problems, err)
+		}
+	}
+	if err := s.Delete("a.paste"); !os.IsNotExist(err) {
+		t.Fatalf("delete missing: got %v, want a not-exist error", err)
+	}
+}
+
+// Pastes stored before blobs are read, listed and replaced in place
+func TestDedupLegacy(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestDedup(t, dir)
+	if err := s.Store.Put("old.paste", []byte("legacy")); err != nil {
+		t.Fatal(err)
+	}
+	s.Put("new.paste", []byte("blob"))
+	s.Put("new.meta", []byte("{}"))
+
+	if got, err := s.Get("old.paste"); err != nil || string(got) != "legacy" {
+		t.Fatalf("legacy paste: %q, %v", got, err)
+	}
+	names, _ := s.List()
+	sort.Strings(names)
+	if strings.Join(names, " ") != "new.meta new.paste old.paste" {
+		t.Fatalf("listed %q", names)
+	}
+
+	if err := s.Put("old.paste", []byte("rewritten")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "old.paste")); !os.IsNotExist(err) {
+		t.Fatalf("legacy copy survived its rewrite: %v", err)
+	}
+	if got, _ := s.Get("old.paste"); string(got) != "rewritten" {
+		t.Fatalf("rewritten paste: %q", got)
+	}
+}
+
+func TestDedupAudit(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestDedup(t, dir)
+	s.Put("a.paste", []byte("one"))
+	s.Put("b.paste", []byte("two"))
+	one, two := sha256Hex([]byte("one")), sha256Hex([]byte("two"))
+
+	ioutil.WriteFile(filepath.Join(dir, one+".refs"), []byte("3"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, two+".blob"), []byte("tampered"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "c.link"), []byte("missing"), 0600)
+
+	problems, err := s.audit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"blob " + one + ": 3 references counted, 1 links",
+		"blob " + two + ": content hashes to " + sha256Hex([]byte("tampered")),
+		"c: links to missing blob missing",
+	}
+	sort.Strings(want)
+	if strings.Join(problems, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("audit found\n%s\nwant\n%s", strings.Join(problems, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// Two stores over one directory, as a server and gopaste rm are, keep the
+// counts right when they share blobs — only the lock file orders them
+func TestDedupSharedDir(t *testing.T) {
+	dir := t.TempDir()
+	a, b := newTestDedup(t, dir), newTestDedup(t, dir)
+
+	var wg sync.WaitGroup
+	for w, s := range []*dedupStore{a, b} {
+		wg.Add(1)
+		go func(w int, s *dedupStore) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				key := strconv.Itoa(w) + "-" + strconv.Itoa(i) + ".paste"
+				if err := s.Put(key, []byte("shared")); err != nil {
+					t.Error(err)
+				}
+				if i%2 == 0 {
+					if err := s.Delete(key); err != nil {
+						t.Error(err)
+					}
+				}
+			}
+		}(w, s)
+	}
+	wg.Wait()
+
+	if n, err := a.refs(sha256Hex([]byte("shared"))); err != nil || n != 50 {
+		t.Fatalf("%d references, %v; want 50", n, err)
+	}
+	if problems, err := a.audit(); err != nil || len(problems) > 0 {
+		t.Fatalf("audit: %q, %v", problems, err)
+	}
+}
+
+// Under encryption blobs are named by keyed HMAC, and still check out
+// after the key rotates
+func TestDedupKeyed(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestDedup(t, dir)
+	s.Put("plain.paste", []byte("older"))
+	s.keys = blobKeys([][]byte{testKey(1)})
+
+	s.Put("a.paste", []byte("guessable"))
+	s.Put("b.paste", []byte("guessable"))
+	if _, err := os.Stat(filepath.Join(dir, sha256Hex([]byte("guessable"))+".blob")); !os.IsNotExist(err) {
+		t.Fatalf("blob named by the bare hash of its content: %v", err)
+	}
+	sum := s.sum([]byte("guessable"))
+	if n, err := s.refs(sum); err != nil || n != 2 {
+		t.Fatalf("%d references to the keyed blob, %v; want 2", n, err)
+	}
+	if other := blobSum(blobKeys([][]byte{testKey(2)})[0], []byte("guessable")); other == sum {
+		t.Fatal("different keys name a blob alike")
+	}
+
+	s.keys = blobKeys([][]byte{testKey(2), testKey(1)})
+	s.Put("c.paste", []byte("guessable"))
+	if problems, err := s.audit(); err != nil || len(problems) > 0 {
+		t.Fatalf("audit after rotation: %q, %v", problems, err)
+	}
+	if got, _ := s.Get("a.paste"); string(got) != "guessable" {
+		t.Fatalf("a.paste: %q", got)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, sum+".blob"), []byte("tampered"), 0600)
+	if problems, _ := s.audit(); len(problems) != 1 {
+		t.Fatalf("audit of a tampered keyed blob: %q", problems)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+// Make a delete token, returning it and the hash kept in metadata
+// Tokens are random enough that a fast hash suffices
+func newDeleteToken() (string, string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Delete token offered by a client, as X-Delete-Token or ?token=
+func requestDeleteToken(r *http.Request) string {
+	if t := r.Header.Get("X-Delete-Token"); t != "" {
+		return t
+	}
+	return r.URL.Query().Get("token")
+}
+
+// Whether the requester may delete a paste — its token, owner or API key
+func canDelete(r *http.Request, m *Meta) bool {
+	if t := requestDeleteToken(r); t != "" && m.DelToken != "" {
+		if subtle.ConstantTimeCompare([]byte(hashToken(t)), []byte(m.DelToken)) == 1 {
+			return true
+		}
+	}
+	return ownsPaste(r, m)
+}
+
+// Delete path handler
+func handleDelete(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["pasteId"]
+	if err := deleteRequested(r, key); err != nil {
+		writeError(w, err)
+		return
+	}
+	fmt.Fprintf(w, "[%s] deleted\n", key)
+}
+
+// Delete a paste if the request holds its token, owner or API key
+func deleteRequested(r *http.Request, key string) error {
+	m, err := readMeta(key)
+	if err == nil {
+		_, err = readPaste(key)
+	}
+	if err != nil || m.Expired() || !canView(r, m) {
+		return httpErrorf(http.StatusNotFound, "[%s] not found", key)
+	}
+
+	if !canDelete(r, m) {
+		return httpErrorf(http.StatusForbidden, "[%s] needs its delete token, owner or API key", key)
+	}
+
+	return deletePaste(key, r)
+}
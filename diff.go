@@ -0,0 +1,372 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// Lines of unchanged context around each hunk
+const diffContext = 3
+
+// A single line-level edit: ' ' keep, '-' delete from a, '+' insert from b
+type diffOp struct {
+	kind byte
+	a    int
+	b    int
+}
+
+// A side-by-side row for the HTML rendering
+type diffRow struct {
+	Kind  string
+	Left  string
+	Right string
+	LNum  int
+	RNum  int
+}
+
+// Diff path handler — compares two stored pastes
+func handleDiff(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	ka, kb := vars["a"], vars["b"]
+
+	ma, pa, ok := openPaste(w, r, ka)
+	if !ok {
+		return
+	}
+	mb, pb, ok := openPaste(w, r, kb)
+	if !ok {
+		return
+	}
+
+	// Showing a burn-after-read paste here would read it without burning it
+	for _, m := range []*Meta{ma, mb} {
+		if m.Burn {
+			writeError(w, httpErrorf(http.StatusForbidden, "[%s] burns after reading and can't be diffed", m.Key))
+			return
+		}
+	}
+
+	a, b := splitLines(string(pa)), splitLines(string(pb))
+	ops, err := diffLines(a, b)
+	if err != nil {
+		writeError(w, httpErrorf(http.StatusUnprocessableEntity, "%v", err))
+		return
+	}
+
+	if wantsHTML(r) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		diffTmpl.Execute(w, struct {
+			A, B string
+			Rows []diffRow
+		}{ka, kb, sideBySide(a, b, ops)})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, unified(ka, kb, a, b, ops))
+}
+
+// Split text into lines, dropping the empty tail after a final newline
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.Split(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Bound on the steps one diff may take through the edit graph, so a pair of
+// large, unrelated pastes can't tie up the server
+const diffMaxWork = 1 << 25
+
+var errDiffTooLarge = errors.New("pastes differ too much to compare")
+
+// Compute a shortest edit script from a to b with the linear-space variant of
+// Myers' algorithm: find the middle snake of an optimal path, then recurse on
+// either side of it
+func diffLines(a, b []string) ([]diffOp, error) {
+	n := len(a) + len(b)
+	d := &differ{a: a, b: b, off: n + 1, work: diffMaxWork}
+	d.vf, d.vb = make([]int, 2*n+3), make([]int, 2*n+3)
+	if err := d.diff(0, len(a), 0, len(b)); err != nil {
+		return nil, err
+	}
+
+	// Within each run of changes, put the deletions first
+	ops := d.ops
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		x, y := ops[i].a, ops[i].b
+		var dels, adds int
+		j := i
+		for ; j < len(ops) && ops[j].kind != ' '; j++ {
+			if ops[j].kind == '-' {
+				dels++
+			} else {
+				adds++
+			}
+		}
+		for n := 0; n < dels; n++ {
+			ops[i+n] = diffOp{'-', x + n, y}
+		}
+		for n := 0; n < adds; n++ {
+			ops[i+dels+n] = diffOp{'+', x + dels, y + n}
+		}
+		i = j
+	}
+	return ops, nil
+}
+
+// State shared by the recursive steps of one diff
+type differ struct {
+	a, b   []string
+	vf, vb []int // furthest x on each diagonal, searching forwards and backwards
+	off    int   // index of diagonal 0 in vf and vb
+	work   int   // steps left before giving up
+	ops    []diffOp
+}
+
+// Append the edits turning a[a0:a1] into b[b0:b1]
+func (d *differ) diff(a0, a1, b0, b1 int) error {
+	for a0 < a1 && b0 < b1 && d.a[a0] == d.b[b0] {
+		d.ops = append(d.ops, diffOp{' ', a0, b0})
+		a0++
+		b0++
+	}
+	tail := 0
+	for a1 > a0 && b1 > b0 && d.a[a1-1] == d.b[b1-1] {
+		a1--
+		b1--
+		tail++
+	}
+
+	switch {
+	case a0 == a1:
+		for y := b0; y < b1; y++ {
+			d.ops = append(d.ops, diffOp{'+', a0, y})
+		}
+	case b0 == b1:
+		for x := a0; x < a1; x++ {
+			d.ops = append(d.ops, diffOp{'-', x, b0})
+		}
+	default:
+		x, y, u, v, err := d.middleSnake(a0, a1, b0, b1)
+		if err != nil {
+			return err
+		}
+		if err := d.diff(a0, x, b0, y); err != nil {
+			return err
+		}
+		for ; x < u; x, y = x+1, y+1 {
+			d.ops = append(d.ops, diffOp{' ', x, y})
+		}
+		if err := d.diff(u, a1, v, b1); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < tail; i++ {
+		d.ops = append(d.ops, diffOp{' ', a1 + i, b1 + i})
+	}
+	return nil
+}
+
+// Find where the forward and backward searches for a shortest path through
+// a[a0:a1] and b[b0:b1] first overlap, returning the start and end of the
+// snake they meet on
+func (d *differ) middleSnake(a0, a1, b0, b1 int) (x0, y0, x1, y1 int, err error) {
+	n, m := a1-a0, b1-b0
+	delta := n - m
+	odd := delta&1 != 0
+	vf, vb, off := d.vf, d.vb, d.off
+	vf[off+1], vb[off+1] = 0, 0
+
+	for D := 0; D <= (n+m+1)/2; D++ {
+		for k := -D; k <= D; k += 2 {
+			var x int
+			if k == -D || (k != D && vf[off+k-1] < vf[off+k+1]) {
+				x = vf[off+k+1]
+			} else {
+				x = vf[off+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && d.a[a0+x] == d.b[b0+y] {
+				x++
+				y++
+			}
+			vf[off+k] = x
+			d.work -= x - sx + 1
+
+			if r := delta - k; odd && -(D-1) <= r && r <= D-1 && x+vb[off+r] >= n {
+				return a0 + sx, b0 + sy, a0 + x, b0 + y, nil
+			}
+		}
+
+		for k := -D; k <= D; k += 2 {
+			var x int
+			if k == -D || (k != D && vb[off+k-1] < vb[off+k+1]) {
+				x = vb[off+k+1]
+			} else {
+				x = vb[off+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && d.a[a1-1-x] == d.b[b1-1-y] {
+				x++
+				y++
+			}
+			vb[off+k] = x
+			d.work -= x - sx + 1
+
+			if r := delta - k; !odd && -D <= r && r <= D && x+vf[off+r] >= n {
+				return a1 - x, b1 - y, a1 - sx, b1 - sy, nil
+			}
+		}
+
+		if d.work < 0 {
+			return 0, 0, 0, 0, errDiffTooLarge
+		}
+	}
+	panic("diff: searches never met")
+}
+
+// Render an edit script as a unified diff
+func unified(na, nb string, a, b []string, ops []diffOp) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", na, nb)
+
+	for i := 0; i < len(ops); {
+		// Find the next change
+		for i < len(ops) && ops[i].kind == ' ' {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+
+		// Extend the hunk while changes are within 2*context of each other
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j
+			} else if j-end > 2*diffContext {
+				break
+			}
+		}
+		end += diffContext + 1
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		var la, lb int
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				la++
+			}
+			if op.kind != '-' {
+				lb++
+			}
+		}
+		sa, sb2 := ops[start].a+1, ops[start].b+1
+		if la == 0 {
+			sa--
+		}
+		if lb == 0 {
+			sb2--
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", sa, la, sb2, lb)
+
+		for _, op := range ops[start:end] {
+			switch op.kind {
+			case ' ':
+				fmt.Fprintf(&sb, " %s\n", a[op.a])
+			case '-':
+				fmt.Fprintf(&sb, "-%s\n", a[op.a])
+			case '+':
+				fmt.Fprintf(&sb, "+%s\n", b[op.b])
+			}
+		}
+		i = end
+	}
+
+	return sb.String()
+}
+
+// Pair deletions with insertions so changed lines sit next to each other
+func sideBySide(a, b []string, ops []diffOp) []diffRow {
+	var rows []diffRow
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			op := ops[i]
+			rows = append(rows, diffRow{"same", a[op.a], b[op.b], op.a + 1, op.b + 1})
+			i++
+			continue
+		}
+
+		var dels, adds []diffOp
+		for ; i < len(ops) && ops[i].kind != ' '; i++ {
+			if ops[i].kind == '-' {
+				dels = append(dels, ops[i])
+			} else {
+				adds = append(adds, ops[i])
+			}
+		}
+
+		for j := 0; j < len(dels) || j < len(adds); j++ {
+			row := diffRow{Kind: "change"}
+			if j < len(dels) {
+				row.Left, row.LNum = a[dels[j].a], dels[j].a+1
+			}
+			if j < len(adds) {
+				row.Right, row.RNum = b[adds[j].b], adds[j].b+1
+			}
+			if j >= len(dels) {
+				row.Kind = "add"
+			} else if j >= len(adds) {
+				row.Kind = "del"
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// Side-by-side diff page for browsers
+var diffTmpl = template.Must(template.New("diff").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.A}} → {{.B}}</title>
+<style>
+body { font-family: monospace; margin: 1em; }
+table { border-collapse: collapse; width: 100%; table-layout: fixed; }
+td { white-space: pre-wrap; word-wrap: break-word; vertical-align: top; padding: 0 .5em; }
+td.n { width: 3em; text-align: right; color: #888; }
+tr.del td.l, tr.change td.l { background: #fdd; }
+tr.add td.r, tr.change td.r { background: #dfd; }
+</style>
+</head>
+<body>
+<table>
+<tr><th></th><th><a href="/{{.A}}">{{.A}}</a></th><th></th><th><a href="/{{.B}}">{{.B}}</a></th></tr>
+{{range .Rows}}<tr class="{{.Kind}}"><td class="n">{{if .LNum}}{{.LNum}}{{end}}</td><td class="l">{{.Left}}</td><td class="n">{{if .RNum}}{{.RNum}}{{end}}</td><td class="r">{{.Right}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
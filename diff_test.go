@@ -0,0 +1,147 @@
+package main
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{"same", "x\ny\n", "x\ny\n", ""},
+		{"empty to text", "", "x\ny\n", "@@ -0,0 +1,2 @@\n+x\n+y\n"},
+		{"text to empty", "x\ny\n", "", "@@ -1,2 +0,0 @@\n-x\n-y\n"},
+		{"change", "a\nb\nc\n", "a\nB\nc\n", "@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n"},
+		{"insert", "a\nc\n", "a\nb\nc\n", "@@ -1,2 +1,3 @@\n a\n+b\n c\n"},
+		{"delete", "a\nb\nc\n", "a\nc\n", "@@ -1,3 +1,2 @@\n a\n-b\n c\n"},
+		{
+			"deletions before insertions",
+			"a\nx\ny\nb\n", "a\np\nq\nb\n",
+			"@@ -1,4 +1,4 @@\n a\n-x\n-y\n+p\n+q\n b\n",
+		},
+		{
+			"separate hunks",
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			"one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve\n",
+			"@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+twelve\n",
+		},
+		{
+			"close changes share a hunk",
+			"1\n2\n3\n4\n5\n6\n7\n8\n",
+			"one\n2\n3\n4\n5\n6\n7\neight\n",
+			"@@ -1,8 +1,8 @@\n-1\n+one\n 2\n 3\n 4\n 5\n 6\n 7\n-8\n+eight\n",
+		},
+	}
+
+	for _, tt := range tests {
+		a, b := splitLines(tt.a), splitLines(tt.b)
+		ops, err := diffLines(a, b)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		got := strings.TrimPrefix(unified("a", "b", a, b, ops), "--- a\n+++ b\n")
+		if got != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Every edit script must turn a into b in as few edits as the LCS allows
+func TestDiffLinesShortest(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	gen := func() []string {
+		s := make([]string, rng.Intn(30))
+		for i := range s {
+			s[i] = string(rune('a' + rng.Intn(4)))
+		}
+		return s
+	}
+
+	for i := 0; i < 2000; i++ {
+		a, b := gen(), gen()
+		ops, err := diffLines(a, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var out []string
+		edits, x, y := 0, 0, 0
+		for _, op := range ops {
+			switch op.kind {
+			case ' ':
+				if op.a != x || op.b != y || a[x] != b[y] {
+					t.Fatalf("%q → %q: bad keep %+v", a, b, op)
+				}
+				out = append(out, a[x])
+				x, y = x+1, y+1
+			case '-':
+				if op.a != x {
+					t.Fatalf("%q → %q: bad delete %+v", a, b, op)
+				}
+				x++
+				edits++
+			case '+':
+				if op.b != y {
+					t.Fatalf("%q → %q: bad insert %+v", a, b, op)
+				}
+				out = append(out, b[y])
+				y++
+				edits++
+			}
+		}
+		if x != len(a) || strings.Join(out, "") != strings.Join(b, "") {
+			t.Fatalf("%q → %q: script gives %q", a, b, out)
+		}
+		if want := len(a) + len(b) - 2*lcs(a, b); edits != want {
+			t.Fatalf("%q → %q: %d edits, want %d", a, b, edits, want)
+		}
+	}
+}
+
+func lcs(a, b []string) int {
+	l := make([][]int, len(a)+1)
+	for i := range l {
+		l[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				l[i][j] = l[i+1][j+1] + 1
+			case l[i+1][j] > l[i][j+1]:
+				l[i][j] = l[i+1][j]
+			default:
+				l[i][j] = l[i][j+1]
+			}
+		}
+	}
+	return l[0][0]
+}
+
+// Unrelated pastes are refused rather than diffed at any cost
+func TestDiffLinesTooLarge(t *testing.T) {
+	a, b := make([]string, 20000), make([]string, 20000)
+	for i := range a {
+		a[i], b[i] = "a"+strconv.Itoa(i), "b"+strconv.Itoa(i)
+	}
+	if _, err := diffLines(a, b); err != errDiffTooLarge {
+		t.Fatalf("got %v, want errDiffTooLarge", err)
+	}
+
+	// Large but similar pastes are fine
+	b = append([]string(nil), a...)
+	b[100], b[15000] = "changed", "changed"
+	ops, err := diffLines(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != len(a)+2 {
+		t.Fatalf("%d ops, want %d", len(ops), len(a)+2)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package main
+
+import (
+	"errors"
+)
+
+// Returned where disk usage can't be measured
+var errNoStatfs = errors.New("disk usage unsupported")
+
+// Disk usage is only measured on systems with statfs(2)
+func diskUsed(dir string) (float64, error) {
+	return 0, errNoStatfs
+}
@@ -0,0 +1,27 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Returned where disk usage can't be measured
+var errNoStatfs = errors.New("disk usage unsupported")
+
+// Percentage of the file system holding dir that is in use
+func diskUsed(dir string) (float64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+
+	total := uint64(st.Blocks) * uint64(st.Bsize)
+	avail := uint64(st.Bavail) * uint64(st.Bsize)
+	if total == 0 {
+		return 0, errNoStatfs
+	}
+	return 100 * float64(total-avail) / float64(total), nil
+}
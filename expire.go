@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often the reaper sweeps for expired pastes
+const reapEvery = time.Hour
+
+// Parse an expiry like 30m, 12h, 7d or 2w — "" or "never" for none
+func parseExpiry(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "never" || s == "0" {
+		return 0, nil
+	}
+
+	unit := time.Duration(0)
+	switch s[len(s)-1] {
+	case 'd':
+		unit = 24 * time.Hour
+	case 'w':
+		unit = 7 * 24 * time.Hour
+	}
+	if unit != 0 {
+		n, err := strconv.ParseFloat(s[:len(s)-1], 64)
+		if err != nil {
+			return 0, err
+		}
+		// NaN fails both comparisons, and past the limit the conversion would wrap
+		if !(n > 0 && n*float64(unit) < math.MaxInt64) {
+			return 0, fmt.Errorf("expiry %q out of range", s)
+		}
+		return time.Duration(n * float64(unit)), nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("expiry %q out of range", s)
+	}
+	return d, nil
+}
+
+// Whether a paste is past its expiry
+func (m *Meta) Expired() bool {
+	return !m.Expires.IsZero() && time.Now().After(m.Expires)
+}
+
+// Delete a paste and its metadata
+func removePaste(key string) error {
+	index.remove(key)
+
+	err := store.Delete(key + ".paste")
+	if merr := store.Delete(key + ".meta"); err == nil && !os.IsNotExist(merr) {
+		err = merr
+	}
+	return err
+}
+
+// Delete a paste on request, recording who asked
+func deletePaste(key string, r *http.Request) error {
+	m, merr := readMeta(key)
+	err := removePaste(key)
+	if err == nil {
+		pastesDeleted.inc()
+		if merr == nil {
+			audit("delete", m, r)
+		}
+	}
+	return err
+}
+
+// Serializes claims on burn-after-read pastes within this process
+var claims sync.Mutex
+
+// Take a burn-after-read paste for one reader by deleting it before it's
+// served — of readers racing for it, only the one whose delete succeeds may
+// have it. S3 deletes succeed on missing objects, hence the check first.
+func claimPaste(key string, r *http.Request) bool {
+	claims.Lock()
+	defer claims.Unlock()
+
+	if _, err := store.Get(key + ".meta"); err != nil {
+		return false
+	}
+	return deletePaste(key, r) == nil
+}
+
+// Delete a paste that has outlived its expiry
+func expirePaste(key string) error {
+	m, merr := readMeta(key)
+	err := removePaste(key)
+	if err == nil {
+		pastesExpired.inc()
+		if merr == nil {
+			audit("expire", m, nil)
+		}
+	}
+	return err
+}
+
+// Load the metadata of every paste passing a filter, newest first
+func listMetas(keep func(*Meta) bool) ([]*Meta, error) {
+	if metaIdx != nil {
+		return metaIdx.list(keep), nil
+	}
+
+	names, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var metas []*Meta
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".meta") {
+			continue
+		}
+		m, err := readMeta(strings.TrimSuffix(name, ".meta"))
+		if err != nil {
+			log.Printf("%s: %v", name, err)
+			continue
+		}
+		if !m.Expired() && keep(m) {
+			metas = append(metas, m)
+		}
+	}
+
+	sort.Slice(metas, func(i, j int) bool { return metas[i].Created.After(metas[j].Created) })
+	return metas, nil
+}
+
+// Delete every expired paste, returning how many went
+func reap() (int, error) {
+	var keys []string
+	if metaIdx != nil {
+		keys = metaIdx.expired()
+	} else {
+		names, err := store.List()
+		if err != nil {
+			return 0, err
+		}
+		for _, name := range names {
+			if !strings.HasSuffix(name, ".meta") {
+				continue
+			}
+			key := strings.TrimSuffix(name, ".meta")
+			if m, err := readMeta(key); err == nil && m.Expired() {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	var n int
+	for _, key := range keys {
+		if err := expirePaste(key); err != nil {
+			log.Printf("reap %s: %v", key, err)
+			continue
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Sweep for expired pastes forever
+func reaper() {
+	for {
+		if n, err := reap(); err != nil {
+			log.Printf("reap: %v", err)
+		} else if n > 0 {
+			log.Printf("reap: removed %d expired pastes", n)
+		}
+		time.Sleep(reapEvery)
+	}
+}
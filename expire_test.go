@@ -0,0 +1,98 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseExpiry(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"", 0, true},
+		{"never", 0, true},
+		{"0", 0, true},
+		{" 12h ", 12 * time.Hour, true},
+		{"30m", 30 * time.Minute, true},
+		{"1h30m", 90 * time.Minute, true},
+		{"7d", 7 * 24 * time.Hour, true},
+		{"1.5d", 36 * time.Hour, true},
+		{"2w", 14 * 24 * time.Hour, true},
+		{"d", 0, false},
+		{"xw", 0, false},
+		{"5y", 0, false},
+		{"soon", 0, false},
+		{"-1h", 0, false},
+		{"0s", 0, false},
+		{"-2d", 0, false},
+		{"0d", 0, false},
+		{"NaNd", 0, false},
+		{"Infw", 0, false},
+		{"+Infd", 0, false},
+		{"1e12w", 0, false},
+		{"15251w", 0, false},
+		{"15000w", 15000 * 7 * 24 * time.Hour, true},
+		{"9999999h", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, err := parseExpiry(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseExpiry(%q): error %v, want ok %v", tt.in, err, tt.ok)
+			continue
+		}
+		if tt.ok && got != tt.want {
+			t.Errorf("parseExpiry(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestExpired(t *testing.T) {
+	tests := []struct {
+		expires time.Time
+		want    bool
+	}{
+		{time.Time{}, false},
+		{time.Now().Add(time.Hour), false},
+		{time.Now().Add(-time.Second), true},
+	}
+	for _, tt := range tests {
+		if got := (&Meta{Expires: tt.expires}).Expired(); got != tt.want {
+			t.Errorf("Expires %v: Expired() = %v, want %v", tt.expires, got, tt.want)
+		}
+	}
+}
+
+// Of readers racing for a burn-after-read paste, exactly one gets it
+func TestClaimPaste(t *testing.T) {
+	defer func(old Store) { store = old }(store)
+	store = &dirStore{dir: t.TempDir()}
+	store.Put("k.paste", []byte("secret"))
+	store.Put("k.meta", []byte(`{"Key":"k","Burn":true}`))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	won := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if claimPaste("k", nil) {
+				mu.Lock()
+				won++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if won != 1 {
+		t.Fatalf("%d readers claimed the paste, want 1", won)
+	}
+	if _, err := store.Get("k.paste"); err == nil {
+		t.Fatal("claimed paste still stored")
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Name of the manifest, always the first entry of an export
+const manifestName = "manifest.json"
+
+// What an export holds, so an import can check every entry
+type manifest struct {
+	Version int
+	Created time.Time
+	Files   []manifestFile
+}
+
+// One stored blob — a paste or its metadata
+type manifestFile struct {
+	Name   string
+	Size   int64
+	SHA256 string
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Names of every paste and its metadata, pastes first within each key
+func exportNames() ([]string, error) {
+	names, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	have := make(map[string]bool)
+	for _, name := range names {
+		have[name] = true
+	}
+
+	var keys []string
+	for _, name := range names {
+		if strings.HasSuffix(name, ".paste") {
+			keys = append(keys, strings.TrimSuffix(name, ".paste"))
+		}
+	}
+	sort.Strings(keys)
+
+	var out []string
+	for _, key := range keys {
+		out = append(out, key+".paste")
+		if have[key+".meta"] {
+			out = append(out, key+".meta")
+		}
+	}
+	return out, nil
+}
+
+// Admin: write every paste and its metadata to a tar archive
+func cmdExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	storeFlags(fs)
+	out := fs.String("o", "-", "Archive to write, - for stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gopaste export [-r root] [-E] [-K keyfile] [-o file]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if _, err := openStore(); err != nil {
+		fatal(err)
+	}
+
+	w := io.Writer(os.Stdout)
+	var f *os.File
+	if *out != "-" {
+		var err error
+		f, err = os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			fatal(err)
+		}
+		w = f
+	}
+
+	n, err := exportArchive(w)
+	if err != nil {
+		fatal(err)
+	}
+	// The archive isn't safely written until the close succeeds
+	if f != nil {
+		if err := f.Close(); err != nil {
+			fatal(err)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "exported %d pastes\n", n)
+}
+
+// Write every paste and its metadata to an archive, returning how many pastes
+func exportArchive(w io.Writer) (int, error) {
+	names, err := exportNames()
+	if err != nil {
+		return 0, err
+	}
+
+	// Checksum everything first so the manifest can lead the archive
+	man := manifest{Version: 1, Created: time.Now().UTC()}
+	for _, name := range names {
+		data, err := store.Get(name)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %v", name, err)
+		}
+		man.Files = append(man.Files, manifestFile{name, int64(len(data)), sha256Hex(data)})
+	}
+
+	tw := tar.NewWriter(w)
+	mb, err := json.MarshalIndent(man, "", "\t")
+	if err != nil {
+		return 0, err
+	}
+	if err := writeTarFile(tw, manifestName, mb, man.Created); err != nil {
+		return 0, err
+	}
+
+	for _, f := range man.Files {
+		data, err := store.Get(f.Name)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %v", f.Name, err)
+		}
+		if sha256Hex(data) != f.SHA256 {
+			return 0, fmt.Errorf("%s changed during export", f.Name)
+		}
+		if err := writeTarFile(tw, f.Name, data, man.Created); err != nil {
+			return 0, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return 0, err
+	}
+	return countPastes(man.Files), nil
+}
+
+// Add one regular file to an archive
+func writeTarFile(tw *tar.Writer, name string, data []byte, mod time.Time) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: mod,
+		Format:  tar.FormatUSTAR,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// The key of an archive entry naming a paste or its metadata — "" for anything else, paths included
+func blobKey(name string) string {
+	var key string
+	switch {
+	case strings.HasSuffix(name, ".paste"):
+		key = strings.TrimSuffix(name, ".paste")
+	case strings.HasSuffix(name, ".meta"):
+		key = strings.TrimSuffix(name, ".meta")
+	}
+	for _, c := range key {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return ""
+		}
+	}
+	return key
+}
+
+func countPastes(files []manifestFile) int {
+	n := 0
+	for _, f := range files {
+		if strings.HasSuffix(f.Name, ".paste") {
+			n++
+		}
+	}
+	return n
+}
+
+// Admin: restore an export into the configured store
+func cmdImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	storeFlags(fs)
+	policy := fs.String("c", "skip", "On a key already in the store: skip or overwrite")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gopaste import [-r root] [-E] [-K keyfile] [-c skip|overwrite] [file]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *policy != "skip" && *policy != "overwrite" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if _, err := openStore(); err != nil {
+		fatal(err)
+	}
+	if strings.HasPrefix(storeSpec, "dir:") {
+		if err := os.MkdirAll(pastePath, 0755); err != nil {
+			fatal(err)
+		}
+	}
+
+	in := io.Reader(os.Stdin)
+	if fs.NArg() == 1 && fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			fatal(err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	n, skipped, err := importArchive(in, *policy == "overwrite")
+	fmt.Fprintf(os.Stderr, "imported %d pastes, skipped %d\n", n, skipped)
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// Restore an archive, checking each entry against the manifest before storing it
+func importArchive(in io.Reader, overwrite bool) (int, int, error) {
+	tr := tar.NewReader(in)
+
+	hdr, err := tr.Next()
+	if err != nil {
+		return 0, 0, fmt.Errorf("reading archive: %v", err)
+	}
+	if hdr.Name != manifestName {
+		return 0, 0, errors.New("not a gopaste export: no manifest first")
+	}
+	var man manifest
+	if err := json.NewDecoder(tr).Decode(&man); err != nil {
+		return 0, 0, fmt.Errorf("manifest: %v", err)
+	}
+	if man.Version != 1 {
+		return 0, 0, fmt.Errorf("manifest version %d not supported", man.Version)
+	}
+
+	want := make(map[string]manifestFile, len(man.Files))
+	for _, f := range man.Files {
+		want[f.Name] = f
+	}
+
+	var n, skipped int
+	skip := make(map[string]bool) // keys left alone because they already exist
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, skipped, fmt.Errorf("reading archive: %v", err)
+		}
+
+		key := blobKey(hdr.Name)
+		if key == "" {
+			return n, skipped, fmt.Errorf("%q: not a paste or its metadata", hdr.Name)
+		}
+		f, ok := want[hdr.Name]
+		if !ok {
+			return n, skipped, fmt.Errorf("%s: not in the manifest", hdr.Name)
+		}
+		delete(want, hdr.Name)
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return n, skipped, fmt.Errorf("%s: %v", hdr.Name, err)
+		}
+		if int64(len(data)) != f.Size || sha256Hex(data) != f.SHA256 {
+			return n, skipped, fmt.Errorf("%s: checksum mismatch", hdr.Name)
+		}
+
+		if strings.HasSuffix(hdr.Name, ".paste") && !overwrite {
+			if _, err := store.Get(hdr.Name); err == nil {
+				skip[key] = true
+			} else if !os.IsNotExist(err) {
+				return n, skipped, fmt.Errorf("%s: %v", hdr.Name, err)
+			}
+		}
+		if skip[key] {
+			if strings.HasSuffix(hdr.Name, ".paste") {
+				skipped++
+			}
+			continue
+		}
+
+		if err := store.Put(hdr.Name, data); err != nil {
+			return n, skipped, fmt.Errorf("%s: %v", hdr.Name, err)
+		}
+		if strings.HasSuffix(hdr.Name, ".paste") {
+			n++
+		}
+	}
+
+	if len(want) > 0 {
+		return n, skipped, fmt.Errorf("archive is missing %d files the manifest lists", len(want))
+	}
+	return n, skipped, nil
+}
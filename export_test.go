@@ -0,0 +1,95 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportImport(t *testing.T) {
+	defer func(old Store) { store = old }(store)
+	src := &dirStore{dir: t.TempDir()}
+	src.Put("aaa.paste", []byte("one"))
+	src.Put("aaa.meta", []byte(`{"Key":"aaa"}`))
+	src.Put("bbb.paste", []byte("two"))
+	src.Put("stray.meta", []byte(`{}`)) // metadata without a paste isn't exported
+
+	store = src
+	var archive bytes.Buffer
+	n, err := exportArchive(&archive)
+	if err != nil || n != 2 {
+		t.Fatalf("exported %d pastes, %v; want 2", n, err)
+	}
+
+	dst := &dirStore{dir: t.TempDir()}
+	store = dst
+	if n, skipped, err := importArchive(bytes.NewReader(archive.Bytes()), false); err != nil || n != 2 || skipped != 0 {
+		t.Fatalf("imported %d, skipped %d, %v", n, skipped, err)
+	}
+	for _, name := range []string{"aaa.paste", "aaa.meta", "bbb.paste"} {
+		want, _ := src.Get(name)
+		if got, err := dst.Get(name); err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("%s: %q, %v; want %q", name, got, err, want)
+		}
+	}
+	if _, err := dst.Get("stray.meta"); err == nil {
+		t.Fatal("exported metadata with no paste")
+	}
+
+	// Skip leaves a key already present whole, paste and metadata both;
+	// overwrite replaces it
+	dst.Put("aaa.paste", []byte("local"))
+	dst.Put("aaa.meta", []byte(`{"Key":"aaa","Lang":"go"}`))
+	if n, skipped, err := importArchive(bytes.NewReader(archive.Bytes()), false); err != nil || n != 0 || skipped != 2 {
+		t.Fatalf("skip: imported %d, skipped %d, %v", n, skipped, err)
+	}
+	if got, _ := dst.Get("aaa.meta"); !strings.Contains(string(got), "go") {
+		t.Fatalf("skip replaced the metadata of a kept paste: %s", got)
+	}
+	if n, skipped, err := importArchive(bytes.NewReader(archive.Bytes()), true); err != nil || n != 2 || skipped != 0 {
+		t.Fatalf("overwrite: imported %d, skipped %d, %v", n, skipped, err)
+	}
+	if got, _ := dst.Get("aaa.paste"); string(got) != "one" {
+		t.Fatalf("overwrite left %q", got)
+	}
+}
+
+func TestImportRefuses(t *testing.T) {
+	defer func(old Store) { store = old }(store)
+	store = &dirStore{dir: t.TempDir()}
+	store.Put("aaa.paste", []byte("one"))
+
+	var good bytes.Buffer
+	if _, err := exportArchive(&good); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := func(entries ...[2]string) []byte {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, e := range entries {
+			writeTarFile(tw, e[0], []byte(e[1]), time.Now())
+		}
+		tw.Close()
+		return buf.Bytes()
+	}
+	man := `{"Version":1,"Files":[{"Name":"aaa.paste","Size":3,"SHA256":"` + sha256Hex([]byte("one")) + `"}]}`
+
+	tests := map[string][]byte{
+		"truncated":       good.Bytes()[:good.Len()-1024-510], // mid-paste
+		"no manifest":     archive([2]string{"aaa.paste", "one"}),
+		"bad version":     archive([2]string{manifestName, `{"Version":2}`}),
+		"tampered":        archive([2]string{manifestName, man}, [2]string{"aaa.paste", "two"}),
+		"unlisted entry":  archive([2]string{manifestName, man}, [2]string{"aaa.paste", "one"}, [2]string{"bbb.paste", "x"}),
+		"path entry":      archive([2]string{manifestName, man}, [2]string{"../aaa.paste", "one"}),
+		"missing entries": archive([2]string{manifestName, man}),
+	}
+	for name, data := range tests {
+		store = &dirStore{dir: t.TempDir()}
+		if _, _, err := importArchive(bytes.NewReader(data), true); err == nil {
+			t.Errorf("%s: imported", name)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Longest ?fmt= template accepted
+const fmtMax = 1024
+
+// Fields a ?fmt= template may name, as {{.Field}} or {{shellquote .Field}}
+var formatFields = []string{"Key", "URL", "RawURL", "Expires", "DeleteToken"}
+
+// How to answer an upload — a bare URL, JSON, or a client's template
+type uploadFormat struct {
+	json bool
+	tmpl string
+}
+
+// Pick the upload response format from ?fmt= or, failing that, the Accept header
+func parseUploadFormat(r *http.Request) (*uploadFormat, error) {
+	f := r.URL.Query().Get("fmt")
+	switch {
+	case f == "":
+		return &uploadFormat{json: strings.Contains(r.Header.Get("Accept"), "application/json")}, nil
+	case f == "plain" || f == "text":
+		return &uploadFormat{}, nil
+	case f == "json":
+		return &uploadFormat{json: true}, nil
+	case !strings.Contains(f, "{{"):
+		return nil, httpErrorf(http.StatusBadRequest, "bad fmt %q: want plain, json or a template", f)
+	case len(f) > fmtMax:
+		return nil, httpErrorf(http.StatusBadRequest, "fmt template longer than %d bytes", fmtMax)
+	}
+
+	// Only the fixed placeholders are expanded — anything else is refused
+	// before anything is stored
+	if strings.Contains(formatReplacer(nil).Replace(f), "{{") {
+		return nil, httpErrorf(http.StatusBadRequest, "bad fmt template: placeholders are {{.%s}} or {{shellquote .Field}}",
+			strings.Join(formatFields, "}}, {{."))
+	}
+	return &uploadFormat{tmpl: f}, nil
+}
+
+// Expand every placeholder for a paste, or to nothing for a nil one
+func formatReplacer(p *apiPaste) *strings.Replacer {
+	vals := make(map[string]string)
+	if p != nil {
+		vals["Key"], vals["URL"], vals["RawURL"], vals["DeleteToken"] = p.Key, p.URL, p.RawURL, p.DeleteToken
+		if p.Expires != nil {
+			vals["Expires"] = p.Expires.Format(time.RFC3339)
+		}
+	}
+
+	var pairs []string
+	for _, field := range formatFields {
+		pairs = append(pairs,
+			"{{."+field+"}}", vals[field],
+			"{{shellquote ."+field+"}}", shellQuote(vals[field]))
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// Answer a stored upload in the chosen format
+func (f *uploadFormat) write(w http.ResponseWriter, r *http.Request, m *Meta, token string) {
+	if token != "" {
+		w.Header().Set("X-Delete-Token", token)
+	}
+
+	switch {
+	case f.json:
+		writeCreated(w, r, m, token)
+	case f.tmpl != "":
+		p := apiDescribe(r, m)
+		p.DeleteToken = token
+
+		out := formatReplacer(&p).Replace(f.tmpl)
+		if !strings.HasSuffix(out, "\n") {
+			out += "\n"
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, out)
+	default:
+		u := proto + r.Host + "/" + m.Key
+		fmt.Fprintf(w, "%s\n", u)
+	}
+}
+
+// Quote a string for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadFormat(t *testing.T) {
+	exp := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	m := &Meta{Key: "abc", Created: exp.Add(-time.Hour), Expires: exp}
+
+	tests := []struct {
+		fmt, accept string
+		code        int
+		want        string
+	}{
+		{"", "", 0, "http://example.com/abc\n"},
+		{"plain", "", 0, "http://example.com/abc\n"},
+		{"[{{.Key}}]({{.URL}})", "", 0, "[abc](http://example.com/abc)\n"},
+		{"{{.RawURL}} {{.Expires}} {{.DeleteToken}}\n", "", 0, "http://example.com/api/v1/pastes/abc/raw 2030-01-02T03:04:05Z tok'en\n"},
+		{"curl {{shellquote .URL}} # {{shellquote .DeleteToken}}", "", 0, `curl 'http://example.com/abc' # 'tok'\''en'` + "\n"},
+		{"soon", "", http.StatusBadRequest, ""},
+		{"{{.Size}}", "", http.StatusBadRequest, ""},
+		{"{{.Ke{{.Key}}y}}", "", http.StatusBadRequest, ""},
+		{"{{printf \"%s\" .Key}}", "", http.StatusBadRequest, ""},
+		{"{{range $i := .Key}}{{range $j := .Key}}x{{end}}{{end}}", "", http.StatusBadRequest, ""},
+		{"{{with .Key}}{{.}}{{end}}", "", http.StatusBadRequest, ""},
+		{strings.Repeat("{{.URL}}", fmtMax), "", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "http://example.com/?fmt="+url.QueryEscape(tt.fmt), nil)
+		f, err := parseUploadFormat(r)
+		if tt.code != 0 {
+			if err == nil || errorStatus(err).code != tt.code {
+				t.Errorf("fmt %.40q: got %v, want status %d", tt.fmt, err, tt.code)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("fmt %.40q: %v", tt.fmt, err)
+			continue
+		}
+
+		w := httptest.NewRecorder()
+		f.write(w, r, m, "tok'en")
+		if got := w.Body.String(); got != tt.want {
+			t.Errorf("fmt %.40q wrote %q, want %q", tt.fmt, got, tt.want)
+		}
+		if got := w.Header().Get("X-Delete-Token"); got != "tok'en" {
+			t.Errorf("fmt %.40q: delete token header %q", tt.fmt, got)
+		}
+	}
+}
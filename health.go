@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Prefix of the names written and removed to prove the store is writable
+const probePrefix = ".readyz-probe-"
+
+// How long a store probe's result is reused, so frequent or concurrent
+// readiness checks don't each write to the store
+const probeEvery = 5 * time.Second
+
+// The latest store probe
+var probe struct {
+	sync.Mutex
+	at     time.Time
+	result check
+}
+
+// Result of one readiness check
+type check struct {
+	OK    bool     `json:"ok"`
+	Error string   `json:"error,omitempty"`
+	Used  *float64 `json:"used_percent,omitempty"`
+}
+
+// Write a JSON health report with a status matching ok
+func writeHealth(w http.ResponseWriter, ok bool, checks map[string]check) {
+	status := "ok"
+	code := http.StatusOK
+	if !ok {
+		status = "unavailable"
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct {
+		Status string           `json:"status"`
+		Checks map[string]check `json:"checks,omitempty"`
+	}{status, checks})
+}
+
+// Liveness handler — answering at all means the process is up
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, true, nil)
+}
+
+// Readiness handler — the store takes writes and the disk has room
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]check{
+		"store": checkStore(),
+		"disk":  checkDisk(),
+	}
+
+	ok := true
+	for _, c := range checks {
+		ok = ok && c.OK
+	}
+	writeHealth(w, ok, checks)
+}
+
+// The store's health, probing it again once the last result is stale
+func checkStore() check {
+	probe.Lock()
+	defer probe.Unlock()
+
+	if time.Since(probe.at) >= probeEvery {
+		probe.result, probe.at = probeStore(), time.Now()
+	}
+	return probe.result
+}
+
+// Round-trip a probe value through the store under a name of its own, so
+// servers sharing the store can't trip over each other's probes
+func probeStore() check {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return check{Error: err.Error()}
+	}
+	name := probePrefix + hex.EncodeToString(b)
+
+	want := []byte(time.Now().String())
+	if err := store.Put(name, want); err != nil {
+		return check{Error: err.Error()}
+	}
+	defer store.Delete(name)
+
+	got, err := store.Get(name)
+	if err != nil {
+		return check{Error: err.Error()}
+	}
+	if !bytes.Equal(got, want) {
+		return check{Error: "probe read back differently"}
+	}
+	return check{OK: true}
+}
+
+// Compare the paste directory's disk usage against -D
+func checkDisk() check {
+	if pastePath == "" {
+		return check{OK: true} // remote stores mind their own disks
+	}
+	used, err := diskUsed(pastePath)
+	if err == errNoStatfs {
+		return check{OK: true}
+	}
+	if err != nil {
+		return check{Error: err.Error()}
+	}
+
+	c := check{OK: used < maxDisk, Used: &used}
+	if !c.OK {
+		c.Error = fmt.Sprintf("disk %.1f%% full, limit %.1f%%", used, maxDisk)
+	}
+	return c
+}
@@ -0,0 +1,21 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package main
+
+import (
+	"os"
+)
+
+// Files are only locked on systems with flock(2)
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func waitLockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
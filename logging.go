@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log levels, least severe first
+const (
+	levelDebug = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+// Parse a log level name
+func parseLevel(s string) (int, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(s, n) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("bad log level %q: want debug, info, warn or error", s)
+}
+
+// Writes one structured record per line, as JSON or logfmt
+type structLog struct {
+	sync.Mutex
+	w     io.Writer
+	json  bool
+	level int
+}
+
+// Access and audit logs — nil until configured
+var (
+	accessLog *structLog
+	auditLog  *structLog
+	anonIPs   bool
+)
+
+// Open a log for a file name, "-" meaning stderr
+func newStructLog(file, format, level string) (*structLog, error) {
+	l := &structLog{w: os.Stderr}
+
+	switch format {
+	case "json":
+		l.json = true
+	case "logfmt":
+	default:
+		return nil, fmt.Errorf("bad log format %q: want json or logfmt", format)
+	}
+
+	lv, err := parseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	l.level = lv
+
+	if file != "-" && file != "" {
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+		if err != nil {
+			return nil, err
+		}
+		l.w = f
+	}
+	return l, nil
+}
+
+// Write a record of alternating keys and values
+func (l *structLog) log(level int, msg string, kv ...interface{}) {
+	if l == nil || level < l.level {
+		return
+	}
+
+	var buf bytes.Buffer
+	ts := time.Now().UTC().Format(time.RFC3339Nano)
+
+	if l.json {
+		rec := map[string]interface{}{"time": ts, "level": levelNames[level], "msg": msg}
+		for i := 0; i+1 < len(kv); i += 2 {
+			rec[fmt.Sprint(kv[i])] = kv[i+1]
+		}
+		b, _ := json.Marshal(rec)
+		buf.Write(b)
+	} else {
+		fmt.Fprintf(&buf, "time=%s level=%s msg=%s", ts, levelNames[level], logfmtValue(msg))
+		for i := 0; i+1 < len(kv); i += 2 {
+			fmt.Fprintf(&buf, " %v=%s", kv[i], logfmtValue(fmt.Sprint(kv[i+1])))
+		}
+	}
+	buf.WriteByte('\n')
+
+	l.Lock()
+	l.w.Write(buf.Bytes())
+	l.Unlock()
+}
+
+// Quote a logfmt value if it needs it
+func logfmtValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\"=\n\\") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// The client's address, anonymized if configured
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !anonIPs {
+		return host
+	}
+
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return host
+	case ip.To4() != nil:
+		return ip.Mask(net.CIDRMask(24, 32)).String()
+	default:
+		return ip.Mask(net.CIDRMask(48, 128)).String()
+	}
+}
+
+// Context key for the per-request log record
+type logKey struct{}
+
+// Fields a handler learns that the access log should carry
+type requestLog struct {
+	key string
+}
+
+// Note the paste a request touched, for handlers that only learn it late
+func logPasteKey(r *http.Request, key string) {
+	if rl, ok := r.Context().Value(logKey{}).(*requestLog); ok {
+		rl.key = key
+	}
+}
+
+// Middleware writing one access log record per request
+func accessMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rl := &requestLog{key: mux.Vars(r)["pasteId"]}
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), logKey{}, rl)))
+
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		level := levelInfo
+		switch {
+		case sw.status >= 500:
+			level = levelError
+		case sw.status >= 400:
+			level = levelWarn
+		}
+
+		accessLog.log(level, "request",
+			"method", r.Method,
+			"route", routeName(r),
+			"path", r.URL.Path,
+			"key", rl.key,
+			"status", sw.status,
+			"bytes", sw.bytes,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"ip", clientIP(r),
+		)
+	})
+}
+
+// Record a paste lifecycle event in the audit log
+func audit(event string, m *Meta, r *http.Request) {
+	kv := []interface{}{"event", event, "key", m.Key}
+	if m.Owner != "" {
+		kv = append(kv, "owner", m.Owner)
+	}
+	if m.Label != "" {
+		kv = append(kv, "apikey", m.Label)
+	}
+	if m.Size > 0 {
+		kv = append(kv, "size", m.Size)
+	}
+	if r != nil {
+		kv = append(kv, "ip", clientIP(r))
+	}
+	auditLog.log(levelInfo, "audit", kv...)
+}
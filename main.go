@@ -1,21 +1,26 @@
 package main
 
 import (
+	"crypto/rand"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/base64"
 	"flag"
 	"fmt"
 	"github.com/gorilla/mux"
-	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
 // Global variables
 var (
 	rootPath	string
 	pastePath	string
+	storeSpec	string
 	tmplPath	string
 	manTitle	string
 	port		string
@@ -23,6 +28,18 @@ var (
 	proto		string = "http://"
 	manCache	map[string]string
 	maxB		int64
+	encRest		bool
+	keyFile		string
+	keysPath	string
+	usersPath	string
+	defExpiry	string
+	accessPath	string
+	auditPath	string
+	logFormat	string
+	logLevel	string
+	maxDisk		float64
+	cacheMax	int64
+	store		Store
 )
 
 // Webpage template
@@ -32,20 +49,227 @@ type Template struct {
 	Lang string
 }
 
-// Host a pastebin-like service
+// Host a pastebin-like service, or run one of the other commands
 func main() {
-	flag.StringVar(&rootPath, "r", "./", "Website root directory")
-	flag.StringVar(&port, "p", ":8001", "Web server port to host on")
-	flag.StringVar(&formVal, "v", "paste", "Form value that appears in 'paste=<-' style form values")
-	flag.StringVar(&manTitle, "m", "isepaste", "Title of man page printed on landing page")
-	flag.Int64Var(&maxB, "s", 10000000, "Max file size in bytes")
-	flag.Parse()
-
-	pastePath	= rootPath + "/pastes/"
+	cmd, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+
+	switch cmd {
+	case "serve":
+		serve(args)
+	case "ls":
+		cmdLs(args)
+	case "put":
+		cmdPut(args)
+	case "get":
+		cmdGet(args)
+	case "rm":
+		// rm <url> <token> is the client; rm <key>... the admin command
+		if clientRm(args) {
+			cmdDelete(args)
+		} else {
+			cmdRm(args)
+		}
+	case "gc":
+		cmdGc(args)
+	case "stats":
+		cmdStats(args)
+	case "verify":
+		cmdVerify(args)
+	case "export":
+		cmdExport(args)
+	case "import":
+		cmdImport(args)
+	case "migrate":
+		cmdMigrate(args)
+	case "reshard":
+		cmdReshard(args)
+	case "encrypt":
+		cmdEncrypt(args)
+	case "decrypt":
+		cmdDecrypt(args)
+	case "useradd":
+		cmdUseradd(args)
+	default:
+		fmt.Fprintf(os.Stderr, "gopaste: unknown command %q\n", cmd)
+		usage()
+		os.Exit(2)
+	}
+}
+
+// List the commands
+func usage() {
+	fmt.Fprint(os.Stderr, `usage: gopaste [command] [flags] [args]
+
+server:
+	serve		run the paste server (the default)
+
+store maintenance:
+	ls		list pastes
+	rm key...	delete pastes
+	gc		delete expired pastes now
+	stats		summarize the store
+	verify		check stored pastes against their keys
+	export		write every paste to a tar archive
+	import [file]	restore pastes from an export
+	migrate		copy the store to another backend
+	reshard		move a flat paste directory into shards
+	useradd name	add an account, reading its password from stdin
+
+client:
+	put [file...]	paste files or stdin and print the URL
+	get url|key	print a paste
+	rm url token	delete a paste with its delete token
+	encrypt url	encrypt stdin and paste it
+	decrypt url#key	fetch and decrypt a paste
+
+Run gopaste <command> -h for a command's flags.
+`)
+}
+
+// Register the flags locating and opening the store
+func storeFlags(fs *flag.FlagSet) {
+	fs.StringVar(&rootPath, "r", "./", "Website root directory")
+	fs.StringVar(&storeSpec, "S", "", "Paste store: dir:<dir>, shard:<dir>, db:<file> or s3:<url>/<bucket> (default dir:<root>/pastes/)")
+	fs.BoolVar(&encRest, "E", false, "Encrypt pastes at rest with the key from -K or $GOPASTE_KEY")
+	fs.StringVar(&keyFile, "K", "", "File of base64 at-rest keys, current key first")
+}
+
+// Open the store the flags describe
+// The encrypting layer is returned too, when there is one, for rewrapping
+func openStore() (*cryptStore, error) {
+	if storeSpec == "" {
+		storeSpec = "dir:" + rootPath + "/pastes/"
+	}
+
+	backend, err := openBackend(storeSpec)
+	if err != nil {
+		return nil, err
+	}
+	store = backend
+	pastePath = storeDir(store)
+
+	var cs *cryptStore
+	var keys [][]byte
+	if encRest {
+		keys, err = loadRestKeys(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cs, err = newCryptStore(store, keys)
+		if err != nil {
+			return nil, err
+		}
+		store = cs
+	} else if err := checkUnsealed(store); err != nil {
+		return nil, err
+	}
+
+	// Blobs sit above the encryption so identical pastes share one however they're sealed
+	// Their counts need every writer in turn, which nothing enforces between
+	// processes sharing an S3 bucket, so S3 pastes are stored whole
+	if _, ok := backend.(*s3Store); !ok {
+		blobs = &dedupStore{Store: store, lockf: openStoreLock(backend), keys: blobKeys(keys)}
+		store = blobs
+		if cs != nil {
+			cs.writers = blobs
+		}
+	}
+
+	// Nothing else can open a database while it's locked, so its metadata can be indexed in memory
+	if _, ok := backend.(*dbStore); ok {
+		metaIdx, err = newMetaIndex(store)
+		if err != nil {
+			return nil, err
+		}
+		store = metaIdx
+	}
+
+	store = &metricsStore{store}
+	return cs, nil
+}
+
+// Run the paste server
+func serve(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	storeFlags(fs)
+	fs.StringVar(&port, "p", ":8001", "Web server port to host on")
+	fs.StringVar(&formVal, "v", "paste", "Form value that appears in 'paste=<-' style form values")
+	fs.StringVar(&manTitle, "m", "isepaste", "Title of man page printed on landing page")
+	fs.Int64Var(&maxB, "s", 10000000, "Max file size in bytes")
+	fs.StringVar(&keysPath, "a", "", "API keys file — when set, uploads require a key")
+	fs.StringVar(&usersPath, "u", "", "Accounts file (default <root>/users)")
+	fs.StringVar(&defExpiry, "x", "never", "Default paste lifetime, e.g. 12h, 7d, 2w or never")
+	fs.StringVar(&accessPath, "l", "-", "Access log file, - for stderr")
+	fs.StringVar(&auditPath, "A", "-", "Audit log file, - for stderr")
+	fs.StringVar(&logFormat, "f", "logfmt", "Access and audit log format: logfmt or json")
+	fs.StringVar(&logLevel, "L", "info", "Access log level: debug, info, warn or error")
+	fs.BoolVar(&anonIPs, "I", false, "Anonymize client IPs in logs")
+	fs.Float64Var(&maxDisk, "D", 95, "Disk usage percentage above which /readyz fails")
+	fs.Int64Var(&cacheMax, "C", 64<<20, "Bytes of pastes to cache in memory, 0 for none")
+	fs.Parse(args)
+
+	var err error
+	accessLog, err = newStructLog(accessPath, logFormat, logLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	auditLog, err = newStructLog(auditPath, logFormat, "info")
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	tmplPath	= rootPath + "/static/"
 	manCache	= make(map[string]string)
 
+	cs, err := openStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cs != nil {
+		// Bring old or plaintext files under the current key
+		go cs.rewrap()
+	}
+
+	// Cache outside the metrics layer so store metrics count only real reads
+	if cacheMax > 0 {
+		store = newCacheStore(store, cacheMax)
+	}
+
+	if keysPath != "" {
+		kr, err := newKeyring(keysPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		apiKeys = kr
+	}
+
+	if usersPath == "" {
+		usersPath = rootPath + "/users"
+	}
+	users = newUserdb(usersPath)
+	if err := initSessions(filepath.Join(filepath.Dir(usersPath), "session.key")); err != nil {
+		log.Fatal(err)
+	}
+
+	if _, err := parseExpiry(defExpiry); err != nil {
+		log.Fatalf("bad default expiry %q: %v", defExpiry, err)
+	}
+	go reaper()
+	go index.rebuild()
+
 	r := mux.NewRouter()
+	r.Use(accessMiddleware, metricsMiddleware)
+
+	// Monitoring — registered before the catch-all paste route
+	r.HandleFunc("/metrics", handleMetrics).Methods("GET")
+	r.HandleFunc("/healthz", handleHealthz).Methods("GET")
+	r.HandleFunc("/readyz", handleReadyz).Methods("GET")
+
+	// JSON API — also ahead of the paste routes
+	apiRoutes(r)
 
 	// Landing on homepage
 	r.HandleFunc("/", handleLand).Methods("GET")
@@ -53,9 +277,34 @@ func main() {
 	// Posting a paste
 	r.HandleFunc("/", handlePaste).Methods("POST")
 
+	// Accounts
+	r.HandleFunc("/login", handleLoginPage).Methods("GET")
+	r.HandleFunc("/login", handleLogin).Methods("POST")
+	r.HandleFunc("/logout", handleLogout).Methods("POST")
+	r.HandleFunc("/~{user}", handleUser).Methods("GET")
+
+	// Discovering public pastes
+	r.HandleFunc("/recent", handleRecent).Methods("GET")
+	r.HandleFunc("/recent.atom", handleRecentAtom).Methods("GET")
+
+	// Searching public pastes
+	r.HandleFunc("/search", handleSearch).Methods("GET")
+
+	// Comparing two pastes
+	r.HandleFunc("/diff/{a}/{b}", handleDiff).Methods("GET")
+
+	// Downloading a bundle as an archive
+	r.HandleFunc("/{pasteId:[A-Za-z0-9_-]+}.{ext:tar\\.gz|zip}", handleArchive).Methods("GET")
+
+	// Reading one file of a bundle
+	r.HandleFunc("/{pasteId}/{filename}", handleBundleFile).Methods("GET")
+
 	// Reading a paste
 	r.HandleFunc("/{pasteId}", handleView).Methods("GET")
 
+	// Deleting a paste
+	r.HandleFunc("/{pasteId}", handleDelete).Methods("DELETE")
+
 	http.Handle("/", r)
 
 	log.Printf("Listening on tcp!*!%s.\n", port[1:])
@@ -66,7 +315,7 @@ func main() {
 func handleLand(w http.ResponseWriter, r *http.Request) {
 	if manCache[r.Host] == "" {		
 		url := proto + r.Host
-		manCache[r.Host] = fmt.Sprintf(man, strings.ToLower(manTitle), strings.ToUpper(manTitle), strings.ToLower(manTitle), strings.ToLower(manTitle), formVal, url, formVal, url, url, url, url, formVal, url, url, formVal, "`", url, url, url )
+		manCache[r.Host] = fmt.Sprintf(man, strings.ToLower(manTitle), strings.ToUpper(manTitle), strings.ToLower(manTitle), strings.ToLower(manTitle), formVal, url, formVal, url, url, url, url, url, url, formVal, url, url, formVal, "`", url, url, url )
 	}
 
 	fmt.Fprint(w, manCache[r.Host])
@@ -74,44 +323,336 @@ func handleLand(w http.ResponseWriter, r *http.Request) {
 
 // Paste path handler — for writing
 func handlePaste(w http.ResponseWriter, r *http.Request) {
-	r.Body = http.MaxBytesReader(w, r.Body, maxB)
+	f, err := parseUploadFormat(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	m, token, err := createPaste(w, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	f.write(w, r, m, token)
+}
+
+// Parse, check and store an upload, returning its metadata and any delete token
+func createPaste(w http.ResponseWriter, r *http.Request) (*Meta, string, error) {
+	limit := maxB
+	if apiKeys != nil {
+		limit = apiKeys.maxSize()
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+
+	// Parse up front — FormValue would hide an oversized upload as an empty paste
+	err := r.ParseMultipartForm(limit)
+	if err == http.ErrNotMultipart {
+		err = r.ParseForm()
+	}
+	if err != nil {
+		return nil, "", httpErrorf(http.StatusBadRequest, "bad upload: %v", err)
+	}
 
 	paste := r.FormValue(formVal)
+	m := &Meta{Created: time.Now()}
+	data := []byte(paste)
+
+	// No paste value but file parts — store them together as a bundle
+	if paste == "" && r.MultipartForm != nil && len(r.MultipartForm.File) > 0 {
+		files, err := formFiles(r.MultipartForm)
+		if err != nil {
+			return nil, "", err
+		}
+
+		data, err = packBundle(files)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, f := range files {
+			m.Files = append(m.Files, f.Name)
+		}
+	}
 
-	// Generate hash to use as filename/key
-	// hash is base64 encoding of the first 72 bits of sha1(paste)
-	h := sha1.New()
-	h.Write([]byte(paste))
-	keyHash := h.Sum(nil)
-	key := base64.URLEncoding.EncodeToString(keyHash[:9])
+	// Logged in users own what they paste — bad credentials are refused outright
+	m.Owner = currentUser(r)
+	if _, _, basic := r.BasicAuth(); basic && m.Owner == "" {
+		e := httpErrorf(http.StatusUnauthorized, "bad user name or password")
+		e.auth = `Basic realm="gopaste"`
+		return nil, "", e
+	}
+
+	k, err := authorizeUpload(r, int64(len(data)))
+	if err != nil {
+		return nil, "", err
+	}
+	if k != nil {
+		m.Label = k.Label
+	}
+
+	exp := r.FormValue("expire")
+	if exp == "" {
+		exp = defExpiry
+	}
+	ttl, err := parseExpiry(exp)
+	if err != nil {
+		return nil, "", httpErrorf(http.StatusBadRequest, "bad expiry %q", exp)
+	}
+	if ttl > 0 {
+		m.Expires = m.Created.Add(ttl)
+	}
+
+	m.Visibility, err = parseVisibility(r.FormValue("visibility"))
+	if err != nil {
+		return nil, "", httpErrorf(http.StatusBadRequest, "%v", err)
+	}
+	if m.Visibility == Private && m.Owner == "" && m.Label == "" {
+		return nil, "", httpErrorf(http.StatusBadRequest, "private pastes need an account or API key to read them back")
+	}
+
+	m.Lang = strings.ToLower(strings.TrimSpace(r.FormValue("lang")))
+
+	// Client-encrypted pastes are stored and served verbatim
+	m.Encrypted = r.FormValue("encrypted") != ""
+
+	if pass := r.FormValue("password"); pass != "" {
+		m.Pass, err = hashPassword(pass)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	// Burn after reading — bundles are read piecemeal so can't be burned
+	m.Burn = r.FormValue("burn") != ""
+	if m.Burn && m.Bundle() {
+		return nil, "", httpErrorf(http.StatusBadRequest, "bundles can't burn after reading")
+	}
+
+	var token string
+	if r.FormValue("token") != "" {
+		token, m.DelToken, err = newDeleteToken()
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	// Protected, owned, keyed, expiring, listed or deletable pastes get a salted key so they never share one with another copy
+	if m.Pass != "" || m.Owner != "" || m.Label != "" || !m.Expires.IsZero() || m.Visibility != Unlisted || m.Burn || m.DelToken != "" {
+		m.Nonce, err = newNonce()
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	key, err := savePaste(data, m)
+	if err != nil {
+		return nil, "", err
+	}
+	logPasteKey(r, key)
+	audit("create", m, r)
+
+	return m, token, nil
+}
+
+// Store a paste and its metadata, returning the key
+func savePaste(data []byte, m *Meta) (string, error) {
+	key := pasteKey(data, m.Nonce)
+
+	// An unsalted key belongs to every copy of its content, so a live paste
+	// keeps the metadata of the upload that made it
+	if m.Nonce == "" {
+		_, err := store.Get(key + ".meta")
+		if err == nil {
+			old, err := readMeta(key)
+			if err != nil {
+				return "", err
+			}
+			if !old.Expired() {
+				*m = *old
+				return key, nil
+			}
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
 
 	// Save our paste
-	f := pastePath + key + ".paste"
-	err := ioutil.WriteFile(f, []byte(paste), 0600)
+	err := store.Put(key+".paste", data)
 	if err != nil {
-		fmt.Fprintf(w, "%s", err)
-		return
+		return "", err
+	}
+
+	m.Key = key
+	m.Size = int64(len(data))
+	if err := writeMeta(m); err != nil {
+		return "", err
+	}
+
+	index.add(m, data)
+	pastesCreated.inc()
+	uploadBytes.observe(float64(m.Size))
+	return key, nil
+}
+
+// Generate hash to use as filename/key
+// hash is base64 encoding of the first 96 bits of sha256(nonce + paste)
+func pasteKey(data []byte, nonce string) string {
+	h := sha256.New()
+	h.Write([]byte(nonce))
+	h.Write(data)
+	keyHash := h.Sum(nil)
+	return base64.URLEncoding.EncodeToString(keyHash[:12])
+}
+
+// Key pastes were given before keys moved to SHA-256
+// hash is base64 encoding of the first 72 bits of sha1(nonce + paste)
+func legacyPasteKey(data []byte, nonce string) string {
+	h := sha1.New()
+	h.Write([]byte(nonce))
+	h.Write(data)
+	keyHash := h.Sum(nil)
+	return base64.URLEncoding.EncodeToString(keyHash[:9])
+}
+
+// The key content should have under the scheme that made key — 12 characters
+// for the old SHA-1 keys, which stay valid, and 16 for SHA-256 ones
+func expectedKey(key string, data []byte, nonce string) string {
+	if len(key) == 12 {
+		return legacyPasteKey(data, nonce)
 	}
+	return pasteKey(data, nonce)
+}
 
-	u := proto + r.Host + "/" + key
-	fmt.Fprintf(w, "%s\n", u)
+// Random value mixed into the key of pastes that must not be found by content
+func newNonce() (string, error) {
+	b := make([]byte, 9)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(b), nil
 }
 
 // View path handler — for reading
 func handleView(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	key := vars["pasteId"]
-	file := pastePath + key + ".paste"
 
-	paste, err := ioutil.ReadFile(file)
-	if err != nil {
-		fmt.Fprintf(w, "[%s] not found", key)
+	m, paste, ok := openPaste(w, r, key)
+	if !ok {
 		return
 	}
+	if m.Burn && !claimPaste(key, r) {
+		http.Error(w, fmt.Sprintf("[%s] not found", key), http.StatusNotFound)
+		return
+	}
+	pastesViewed.inc()
+
+	if m.Bundle() {
+		listBundle(w, r, m)
+		return
+	}
+
+	// Browsers decrypt client-encrypted pastes themselves
+	if m.Encrypted && wantsHTML(r) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		decryptTmpl.Execute(w, struct {
+			Key       string
+			Body      string
+			Prefix    string
+			PrefixLen int
+		}{key, string(paste), encPrefix, len(encPrefix)})
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "%s", paste)
+	}
+}
+
+// Look up a stored paste by key
+func readPaste(key string) ([]byte, error) {
+	return store.Get(key + ".paste")
+}
+
+// Look up a paste and check the request may read it
+// On failure the error response has already been written
+func openPaste(w http.ResponseWriter, r *http.Request, key string) (*Meta, []byte, bool) {
+	m, paste, err := lookupPaste(r, key)
+	if e, ok := err.(*httpError); ok && e.code == http.StatusUnauthorized {
+		askPassword(w, r, key)
+		return nil, nil, false
+	}
+	if err != nil {
+		writeError(w, err)
+		return nil, nil, false
+	}
+	return m, paste, true
+}
+
+// Read a paste the request may see, checking expiry, visibility and password
+func lookupPaste(r *http.Request, key string) (*Meta, []byte, error) {
+	paste, err := readPaste(key)
+	if err != nil {
+		return nil, nil, httpErrorf(http.StatusNotFound, "[%s] not found", key)
+	}
+
+	m, err := readMeta(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if m.Expired() {
+		expirePaste(key)
+		return nil, nil, httpErrorf(http.StatusNotFound, "[%s] not found", key)
+	}
+
+	// Private pastes are indistinguishable from missing ones to others
+	if !canView(r, m) {
+		return nil, nil, httpErrorf(http.StatusNotFound, "[%s] not found", key)
+	}
+
+	if m.Pass != "" && !checkPassword(requestPassword(r), m.Pass) {
+		e := httpErrorf(http.StatusUnauthorized, "[%s] needs a password", key)
+		e.auth = `Basic realm="gopaste"`
+		return nil, nil, e
+	}
+
+	return m, paste, nil
+}
+
+// Whether the client is a browser asking for HTML rather than curl et al.
+func wantsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// An error with the HTTP status it should be answered with
+type httpError struct {
+	code int
+	msg  string
+	auth string // WWW-Authenticate challenge, if any
+}
+
+func (e *httpError) Error() string {
+	return e.msg
+}
+
+func httpErrorf(code int, format string, a ...interface{}) *httpError {
+	return &httpError{code: code, msg: fmt.Sprintf(format, a...)}
+}
+
+// The status, message and challenge for an error — anything untyped is a 500
+func errorStatus(err error) *httpError {
+	if e, ok := err.(*httpError); ok {
+		return e
+	}
+	return &httpError{code: http.StatusInternalServerError, msg: err.Error()}
+}
 
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	fmt.Fprintf(w, "%s", paste)
-	return
+// Answer with an error as plain text
+func writeError(w http.ResponseWriter, err error) {
+	e := errorStatus(err)
+	if e.auth != "" {
+		w.Header().Set("WWW-Authenticate", e.auth)
+	}
+	http.Error(w, e.msg, e.code)
 }
 
 // Manual for port landing page printing
@@ -126,6 +667,57 @@ SYNOPSIS
 DESCRIPTION
 	Paste to a listening plaintext paste server.
 
+	GET /diff/<a>/<b> shows a unified diff between two pastes.
+
+	Several files may be pasted at once as a bundle. The paste URL
+	then lists the files, each served at <url>/<name>, and the whole
+	bundle downloads from <url>.tar.gz or <url>.zip.
+
+	A password=<secret> form value protects a paste. Readers must
+	then supply the password as HTTP Basic auth or as ?p=<secret>.
+
+	Pastes may be encrypted before upload so the server never sees
+	their contents; the key travels only in the URL fragment. Use
+	"gopaste encrypt <url>" and "gopaste decrypt <url#key>", or open
+	the link in a browser to decrypt it there.
+
+	Servers may require an API key to paste, given as an
+	"Authorization: Bearer <key>" header or an apikey=<key> form value.
+
+	An expire=<duration> form value (30m, 12h, 7d, 2w, never) sets
+	how long a paste lives. burn=1 deletes the paste once it has
+	been read. token=1 returns a delete token in the X-Delete-Token
+	header; DELETE <url> with that header (or ?token=) removes the
+	paste. Owners and uploading API keys may delete without one.
+
+	With an account, paste with curl -u <user> or after POST /login
+	to own the paste. GET /~<user> then lists your pastes with their
+	size, date and expiry.
+
+	A visibility=<level> form value picks who can find a paste:
+	public pastes are listed, unlisted ones (the default) need the
+	link, and private ones are readable only by their owner or the
+	API key that uploaded them.
+
+	GET /recent lists recent public pastes and GET /recent.atom is
+	an Atom feed of them, both paged with ?page=<n>.
+
+	GET /search?q=<query> searches public pastes. A query is words,
+	"quoted phrases", lang:<lang> and user:<name>, all of which must
+	match. Tag a paste's language with a lang=<lang> form value.
+
+	A ?fmt= query picks the upload response: plain (the URL, the
+	default), json (also chosen by Accept: application/json), or a
+	template such as [{{.Key}}]({{.URL}}) over .Key, .URL, .RawURL,
+	.Expires and .DeleteToken, or {{shellquote .URL}} and the like.
+
+	Programs may use the JSON API under /api/v1 instead: POST
+	/api/v1/pastes creates a paste from the same form values or a
+	JSON body, GET /api/v1/pastes/<key> returns its metadata, .../raw
+	its content, and DELETE removes it. GET /api/v1/pastes lists
+	public pastes, or your own with ?mine=1. The OpenAPI document is
+	at /api/v1/openapi.json.
+
 EXAMPLES
 	Paste the file bin/myscript and open the link in firefox(1) from unix:
 
@@ -133,6 +725,11 @@ EXAMPLES
 		%s/aXZI
 		~$ firefox %s/aXZI
 
+	Paste two files as one bundle:
+
+		~$ curl -F 'f1=@main.go' -F 'f2=@go.mod' %s
+		%s/bXZJ
+
 	Paste the file bin/rc/myscript and plumb the link from Plan 9:
 
 		%% cat bin/rc/myscript | hpost -u %s -p / %s@/fd/0
@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestPasteKeys(t *testing.T) {
+	tests := []struct {
+		data, nonce string
+		key, legacy string
+	}{
+		{"", "", "47DEQpj8HBSa-_TI", "2jmj7l5rSw0y"},
+		{"hello\n", "", "WJG1tSLV3whtD_Cx", "9XLTlvrpIGYo"},
+		{"hello\n", "nonce", "oriqofA9vnZ5X54X", "vB4PMeYwTqxI"},
+	}
+
+	for _, tt := range tests {
+		data := []byte(tt.data)
+		if got := pasteKey(data, tt.nonce); got != tt.key {
+			t.Errorf("pasteKey(%q, %q) = %s, want %s", tt.data, tt.nonce, got, tt.key)
+		}
+		if got := legacyPasteKey(data, tt.nonce); got != tt.legacy {
+			t.Errorf("legacyPasteKey(%q, %q) = %s, want %s", tt.data, tt.nonce, got, tt.legacy)
+		}
+
+		// Each key is checked by the scheme that made it
+		if got := expectedKey(tt.key, data, tt.nonce); got != tt.key {
+			t.Errorf("expectedKey(%s) = %s", tt.key, got)
+		}
+		if got := expectedKey(tt.legacy, data, tt.nonce); got != tt.legacy {
+			t.Errorf("expectedKey(%s) = %s", tt.legacy, got)
+		}
+	}
+}
+
+func TestNonce(t *testing.T) {
+	a, err := newNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := newNonce()
+	if len(a) != 12 || a == b {
+		t.Fatalf("nonces %q and %q: want distinct 12-character values", a, b)
+	}
+	if pasteKey([]byte("x"), a) == pasteKey([]byte("x"), b) {
+		t.Fatal("salted copies share a key")
+	}
+}
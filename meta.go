@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// Paste metadata, stored next to the paste as <key>.meta
+type Meta struct {
+	Key     string
+	Created time.Time
+	Size    int64
+	Files   []string `json:",omitempty"`
+	Pass    string   `json:",omitempty"`
+	Nonce   string   `json:",omitempty"`
+	Label   string   `json:",omitempty"` // API key used to upload
+	Owner   string   `json:",omitempty"`
+	Lang    string   `json:",omitempty"`
+	Expires time.Time
+
+	Visibility string `json:",omitempty"`
+
+	Burn     bool   `json:",omitempty"` // delete once read
+	DelToken string `json:",omitempty"` // sha256 of the delete token
+
+	// Encrypted by the client — the server holds only ciphertext
+	Encrypted bool `json:",omitempty"`
+}
+
+// Whether the paste is a multi-file bundle
+func (m *Meta) Bundle() bool {
+	return len(m.Files) > 0
+}
+
+// Load a paste's metadata — pastes from before metadata existed get a bare Meta
+func readMeta(key string) (*Meta, error) {
+	m := &Meta{Key: key}
+
+	b, err := store.Get(key + ".meta")
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, m)
+	return m, err
+}
+
+// Save a paste's metadata
+func writeMeta(m *Meta) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return store.Put(m.Key+".meta", b)
+}
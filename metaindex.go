@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Every paste's metadata held in memory, ordered by creation and by expiry, so
+// listings and the expiry sweep never read the store. It is kept current by
+// the writes passing through it, so it is only used over a database store,
+// whose lock means no other process can change the metadata underneath.
+type metaIndex struct {
+	Store
+	sync.RWMutex
+	metas   map[string]*Meta
+	created []*Meta // oldest first
+	expires []*Meta // expiring pastes, soonest first
+}
+
+// The running metadata index, when the store has one
+var metaIdx *metaIndex
+
+// Index the metadata already in a store
+func newMetaIndex(s Store) (*metaIndex, error) {
+	ix := &metaIndex{Store: s, metas: make(map[string]*Meta)}
+
+	names, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".meta") {
+			continue
+		}
+		data, err := s.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		if err := ix.set(name, data); err != nil {
+			log.Printf("%s: %v", name, err)
+		}
+	}
+	return ix, nil
+}
+
+func (ix *metaIndex) Put(name string, data []byte) error {
+	if err := ix.Store.Put(name, data); err != nil {
+		return err
+	}
+	if strings.HasSuffix(name, ".meta") {
+		if err := ix.set(name, data); err != nil {
+			log.Printf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (ix *metaIndex) Delete(name string) error {
+	err := ix.Store.Delete(name)
+	if strings.HasSuffix(name, ".meta") {
+		ix.Lock()
+		ix.removeLocked(strings.TrimSuffix(name, ".meta"))
+		ix.Unlock()
+	}
+	return err
+}
+
+// Index a stored .meta value, replacing any earlier one for its key
+func (ix *metaIndex) set(name string, data []byte) error {
+	key := strings.TrimSuffix(name, ".meta")
+	m := &Meta{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return err
+	}
+	m.Key = key
+
+	ix.Lock()
+	defer ix.Unlock()
+
+	ix.removeLocked(key)
+	ix.metas[key] = m
+	ix.created = insertMeta(ix.created, m, func(m *Meta) time.Time { return m.Created })
+	if !m.Expires.IsZero() {
+		ix.expires = insertMeta(ix.expires, m, func(m *Meta) time.Time { return m.Expires })
+	}
+	return nil
+}
+
+func (ix *metaIndex) removeLocked(key string) {
+	m, ok := ix.metas[key]
+	if !ok {
+		return
+	}
+	delete(ix.metas, key)
+	ix.created = removeMeta(ix.created, m, func(m *Meta) time.Time { return m.Created })
+	if !m.Expires.IsZero() {
+		ix.expires = removeMeta(ix.expires, m, func(m *Meta) time.Time { return m.Expires })
+	}
+}
+
+// Copies of the unexpired metadata passing a filter, newest first
+func (ix *metaIndex) list(keep func(*Meta) bool) []*Meta {
+	ix.RLock()
+	defer ix.RUnlock()
+
+	var metas []*Meta
+	for i := len(ix.created) - 1; i >= 0; i-- {
+		m := *ix.created[i]
+		if !m.Expired() && keep(&m) {
+			metas = append(metas, &m)
+		}
+	}
+	return metas
+}
+
+// Keys of the pastes past their expiry
+func (ix *metaIndex) expired() []string {
+	ix.RLock()
+	defer ix.RUnlock()
+
+	var keys []string
+	for _, m := range ix.expires {
+		if !m.Expired() {
+			break
+		}
+		keys = append(keys, m.Key)
+	}
+	return keys
+}
+
+// Insert into a slice kept sorted by a time, after any equal times
+func insertMeta(list []*Meta, m *Meta, at func(*Meta) time.Time) []*Meta {
+	t := at(m)
+	i := sort.Search(len(list), func(i int) bool { return at(list[i]).After(t) })
+	list = append(list, nil)
+	copy(list[i+1:], list[i:])
+	list[i] = m
+	return list
+}
+
+// Remove from a slice kept sorted by a time
+func removeMeta(list []*Meta, m *Meta, at func(*Meta) time.Time) []*Meta {
+	t := at(m)
+	for i := sort.Search(len(list), func(i int) bool { return !at(list[i]).Before(t) }); i < len(list); i++ {
+		if list[i] == m {
+			return append(list[:i], list[i+1:]...)
+		}
+	}
+	return list
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A metric that can write itself in the Prometheus text format
+type metric interface {
+	write(w io.Writer)
+}
+
+// Every metric exposed on /metrics, in registration order
+var metrics []metric
+
+// A set of counters or gauges split by label values
+type counterVec struct {
+	sync.Mutex
+	name   string
+	help   string
+	kind   string
+	labels []string
+	vals   map[string]float64
+}
+
+func newCounter(name, help string, labels ...string) *counterVec {
+	c := &counterVec{name: name, help: help, kind: "counter", labels: labels, vals: make(map[string]float64)}
+	metrics = append(metrics, c)
+	return c
+}
+
+func newGauge(name, help string, labels ...string) *counterVec {
+	c := newCounter(name, help, labels...)
+	c.kind = "gauge"
+	return c
+}
+
+func (c *counterVec) add(v float64, lv ...string) {
+	c.Lock()
+	c.vals[strings.Join(lv, "\xff")] += v
+	c.Unlock()
+}
+
+func (c *counterVec) inc(lv ...string) {
+	c.add(1, lv...)
+}
+
+func (c *counterVec) set(v float64, lv ...string) {
+	c.Lock()
+	c.vals[strings.Join(lv, "\xff")] = v
+	c.Unlock()
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.Lock()
+	defer c.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", c.name, c.help, c.name, c.kind)
+	if len(c.labels) == 0 && len(c.vals) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.name)
+	}
+	for _, k := range sortedKeys(c.vals) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labelString(c.labels, k, ""), formatFloat(c.vals[k]))
+	}
+}
+
+// A set of histograms split by label values
+type histogramVec struct {
+	sync.Mutex
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	vals    map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *histogramVec {
+	h := &histogramVec{name: name, help: help, labels: labels, buckets: buckets, vals: make(map[string]*histogram)}
+	metrics = append(metrics, h)
+	return h
+}
+
+func (h *histogramVec) observe(v float64, lv ...string) {
+	k := strings.Join(lv, "\xff")
+
+	h.Lock()
+	defer h.Unlock()
+
+	hh := h.vals[k]
+	if hh == nil {
+		hh = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.vals[k] = hh
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			hh.counts[i]++
+		}
+	}
+	hh.sum += v
+	hh.count++
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.Lock()
+	defer h.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.vals))
+	for k := range h.vals {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		hh := h.vals[k]
+		for i, b := range h.buckets {
+			le := `le="` + formatFloat(b) + `"`
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelString(h.labels, k, le), hh.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelString(h.labels, k, `le="+Inf"`), hh.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labelString(h.labels, k, ""), formatFloat(hh.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelString(h.labels, k, ""), hh.count)
+	}
+}
+
+// Render {a="x",b="y"} from label names and joined values, plus any extra pair
+func labelString(names []string, joined, extra string) string {
+	var pairs []string
+	if len(names) > 0 {
+		for i, v := range strings.Split(joined, "\xff") {
+			if i < len(names) {
+				pairs = append(pairs, names[i]+"="+strconv.Quote(v))
+			}
+		}
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, +1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Exported metrics
+var (
+	pastesCreated = newCounter("gopaste_pastes_created_total", "Pastes stored.")
+	pastesViewed  = newCounter("gopaste_pastes_viewed_total", "Pastes served.")
+	pastesDeleted = newCounter("gopaste_pastes_deleted_total", "Pastes deleted on request.")
+	pastesExpired = newCounter("gopaste_pastes_expired_total", "Pastes removed after expiring.")
+
+	uploadBytes = newHistogram("gopaste_upload_bytes", "Size of stored pastes in bytes.",
+		[]float64{100, 1e3, 1e4, 1e5, 1e6, 1e7, 1e8})
+
+	httpRequests = newCounter("gopaste_http_requests_total", "HTTP requests by route, method and status code.",
+		"route", "method", "code")
+	httpDuration = newHistogram("gopaste_http_request_duration_seconds", "HTTP request latency by route.",
+		[]float64{.001, .005, .01, .05, .1, .5, 1, 5}, "route", "method")
+
+	storeOps      = newCounter("gopaste_store_operations_total", "Store operations by kind.", "op")
+	storeErrors   = newCounter("gopaste_store_errors_total", "Failed store operations by kind, not counting missing names.", "op")
+	storeDuration = newHistogram("gopaste_store_operation_duration_seconds", "Store operation latency.",
+		[]float64{.0001, .001, .01, .1, 1}, "op")
+
+	storePastes = newGauge("gopaste_store_pastes", "Pastes in the store.")
+	storeBytes  = newGauge("gopaste_store_bytes", "Total size of pastes in the store.")
+
+	cacheHits      = newCounter("gopaste_cache_hits_total", "Store reads answered from the in-memory cache.")
+	cacheMisses    = newCounter("gopaste_cache_misses_total", "Store reads the in-memory cache had to pass on.")
+	cacheEvictions = newCounter("gopaste_cache_evictions_total", "Blobs evicted from the in-memory cache to make room.")
+	cacheBytes     = newGauge("gopaste_cache_bytes", "Bytes held in the in-memory cache.")
+)
+
+// How stale the store size gauges may get before a scrape recounts them
+const storeSizeEvery = time.Minute
+
+var (
+	storeSizeLock sync.Mutex
+	storeSizeAt   time.Time
+)
+
+// Recount the store size gauges if they are stale
+func updateStoreSize() {
+	storeSizeLock.Lock()
+	defer storeSizeLock.Unlock()
+
+	if time.Since(storeSizeAt) < storeSizeEvery {
+		return
+	}
+	metas, err := listMetas(func(*Meta) bool { return true })
+	if err != nil {
+		return
+	}
+
+	var total int64
+	for _, m := range metas {
+		total += m.Size
+	}
+	storePastes.set(float64(len(metas)))
+	storeBytes.set(float64(total))
+	storeSizeAt = time.Now()
+}
+
+// Metrics handler — Prometheus text exposition format
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	updateStoreSize()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// A ResponseWriter that remembers the status and size of the response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if sw.status == 0 {
+		sw.status = code
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(b)
+	sw.bytes += int64(n)
+	return n, err
+}
+
+// Route template of a matched request, e.g. /{pasteId}
+func routeName(r *http.Request) string {
+	if rt := mux.CurrentRoute(r); rt != nil {
+		if t, err := rt.GetPathTemplate(); err == nil {
+			return t
+		}
+	}
+	return "other"
+}
+
+// Middleware counting requests and timing them per route
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		route := routeName(r)
+		httpRequests.inc(route, r.Method, strconv.Itoa(sw.status))
+		httpDuration.observe(time.Since(start).Seconds(), route, r.Method)
+	})
+}
+
+// Counts and times every operation on the wrapped store
+type metricsStore struct {
+	Store
+}
+
+func (s *metricsStore) observe(op string, start time.Time, err error) {
+	storeOps.inc(op)
+	storeDuration.observe(time.Since(start).Seconds(), op)
+	if err != nil && !os.IsNotExist(err) {
+		storeErrors.inc(op)
+	}
+}
+
+func (s *metricsStore) Get(name string) ([]byte, error) {
+	start := time.Now()
+	data, err := s.Store.Get(name)
+	s.observe("get", start, err)
+	return data, err
+}
+
+func (s *metricsStore) Put(name string, data []byte) error {
+	start := time.Now()
+	err := s.Store.Put(name, data)
+	s.observe("put", start, err)
+	return err
+}
+
+func (s *metricsStore) Delete(name string) error {
+	start := time.Now()
+	err := s.Store.Delete(name)
+	s.observe("delete", start, err)
+	return err
+}
+
+func (s *metricsStore) List() ([]string, error) {
+	start := time.Now()
+	names, err := s.Store.List()
+	s.observe("list", start, err)
+	return names, err
+}
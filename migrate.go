@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Admin: copy every paste and its metadata from one store to another
+func cmdMigrate(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := fs.String("from", "", "Store to copy from, e.g. dir:/old/pastes")
+	to := fs.String("to", "", "Store to copy to")
+	workers := fs.Int("j", 8, "Pastes to copy at once")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: gopaste migrate -from <store> -to <store> [-j n]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *from == "" || *to == "" || *workers < 1 || fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	src, err := openBackend(*from)
+	if err != nil {
+		fatal(err)
+	}
+	dst, err := openBackend(*to)
+	if err != nil {
+		fatal(err)
+	}
+	if d, ok := dst.(*dirStore); ok {
+		if err := os.MkdirAll(d.dir, 0755); err != nil {
+			fatal(err)
+		}
+	}
+
+	names, err := src.List()
+	if err != nil {
+		fatal(err)
+	}
+
+	// Each key's blobs travel together, the paste ahead of its metadata
+	groups := make(map[string][]string)
+	for _, name := range names {
+		key := name
+		if i := strings.LastIndexByte(name, '.'); i > 0 {
+			key = name[:i]
+		}
+		groups[key] = append(groups[key], name)
+	}
+	keys := make([]string, 0, len(groups))
+	for key, g := range groups {
+		sort.Sort(sort.Reverse(sort.StringSlice(g))) // .paste sorts after .meta
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var copied, present, failed int64
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < *workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range work {
+				for _, name := range groups[key] {
+					done, err := migrateBlob(src, dst, name)
+					switch {
+					case err != nil:
+						fmt.Fprintf(os.Stderr, "gopaste: %s: %v\n", name, err)
+						atomic.AddInt64(&failed, 1)
+					case done:
+						atomic.AddInt64(&copied, 1)
+					default:
+						atomic.AddInt64(&present, 1)
+					}
+				}
+			}
+		}()
+	}
+	for _, key := range keys {
+		work <- key
+	}
+	close(work)
+	wg.Wait()
+
+	fmt.Printf("%d copied, %d already there, %d failed\n", copied, present, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// Copy one blob unless the destination already holds it, checking the copy reads back intact
+// Blobs are copied as stored, so pastes encrypted at rest stay encrypted under the same keys,
+// and streamed, so large pastes never sit in memory where both stores can stream
+func migrateBlob(src, dst Store, name string) (bool, error) {
+	ss, ds := streamsOf(src), streamsOf(dst)
+
+	sum, err := hashBlob(ss, name)
+	if err != nil {
+		return false, err
+	}
+
+	// Resuming: anything already copied intact is left alone
+	if have, err := hashBlob(ds, name); err == nil {
+		if have == sum {
+			return false, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	rc, size, err := ss.GetStream(name)
+	if err != nil {
+		return false, err
+	}
+	h := sha256.New()
+	err = ds.PutStream(name, io.TeeReader(rc, h), size)
+	rc.Close()
+	if err != nil {
+		return false, err
+	}
+	var sent [sha256.Size]byte
+	copy(sent[:], h.Sum(nil))
+	if sent != sum {
+		return false, errors.New("changed while being copied")
+	}
+
+	back, err := hashBlob(ds, name)
+	if err != nil {
+		return false, fmt.Errorf("reading back: %v", err)
+	}
+	if back != sum {
+		return false, fmt.Errorf("copy does not match: sha256 %x, want %x", back, sum)
+	}
+	return true, nil
+}
+
+// SHA-256 of a stored blob, read as a stream
+func hashBlob(s streamStore, name string) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	rc, _, err := s.GetStream(name)
+	if err != nil {
+		return sum, err
+	}
+	defer rc.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// PBKDF2 work factor for stored password hashes
+const pbkdf2Iter = 100000
+
+// Hash a password as pbkdf2-sha256$<iter>$<salt>$<hash>
+func hashPassword(pass string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	dk := pbkdf2([]byte(pass), salt, pbkdf2Iter, sha256.Size)
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", pbkdf2Iter, enc.EncodeToString(salt), enc.EncodeToString(dk)), nil
+}
+
+// Check a password against a stored hash in constant time
+func checkPassword(pass, stored string) bool {
+	parts := strings.Split(stored, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+
+	iter, err := strconv.Atoi(parts[1])
+	if err != nil || iter < 1 {
+		return false
+	}
+	enc := base64.RawStdEncoding
+	salt, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := enc.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+
+	got := pbkdf2([]byte(pass), salt, iter, len(want))
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// Password offered by a reader, from Basic auth or ?p=
+func requestPassword(r *http.Request) string {
+	if _, pass, ok := r.BasicAuth(); ok {
+		return pass
+	}
+	return r.URL.Query().Get("p")
+}
+
+// Challenge for a password — a prompt page for browsers, Basic auth otherwise
+func askPassword(w http.ResponseWriter, r *http.Request, key string) {
+	if wantsHTML(r) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusUnauthorized)
+		passTmpl.Execute(w, struct {
+			Path  string
+			Wrong bool
+		}{r.URL.Path, requestPassword(r) != ""})
+		return
+	}
+
+	w.Header().Set("WWW-Authenticate", `Basic realm="`+key+`"`)
+	http.Error(w, fmt.Sprintf("[%s] requires a password", key), http.StatusUnauthorized)
+}
+
+// PBKDF2 with HMAC-SHA256 (RFC 8018)
+func pbkdf2(pass, salt []byte, iter, keyLen int) []byte {
+	prf := hmac.New(sha256.New, pass)
+	hLen := prf.Size()
+	blocks := (keyLen + hLen - 1) / hLen
+
+	var buf [4]byte
+	dk := make([]byte, 0, blocks*hLen)
+	u := make([]byte, hLen)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf[:], uint32(block))
+		prf.Write(buf[:])
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-hLen:]
+		copy(u, t)
+
+		for n := 2; n <= iter; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = u[:0]
+			u = prf.Sum(u)
+			for i := range u {
+				t[i] ^= u[i]
+			}
+		}
+	}
+
+	return dk[:keyLen]
+}
+
+// Password prompt page for browsers
+var passTmpl = template.Must(template.New("pass").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Password required</title>
+</head>
+<body>
+<form method="GET" action="{{.Path}}">
+{{if .Wrong}}<p>Wrong password.</p>
+{{end}}<label>Password: <input type="password" name="p" autofocus></label>
+<input type="submit" value="View">
+</form>
+</body>
+</html>
+`))
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// PBKDF2-HMAC-SHA256 vectors from RFC 7914 and the RFC 6070 inputs
+func TestPBKDF2(t *testing.T) {
+	tests := []struct {
+		pass, salt string
+		iter       int
+		want       string
+	}{
+		{"password", "salt", 1, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{"password", "salt", 2, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{"password", "salt", 4096, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+		{
+			"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096,
+			"348c89dbcbd32b2f32d814b8116e84cf2b17347ebc1800181c4e2a1fb8dd53e1c635518c7dac47e9",
+		},
+		{"pass\x00word", "sa\x00lt", 4096, "89b69d0516f829893c696226650a8687"},
+		{
+			"passwd", "salt", 1,
+			"55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+				"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783",
+		},
+	}
+
+	for _, tt := range tests {
+		got := hex.EncodeToString(pbkdf2([]byte(tt.pass), []byte(tt.salt), tt.iter, len(tt.want)/2))
+		if got != tt.want {
+			t.Errorf("pbkdf2(%q, %q, %d) = %s, want %s", tt.pass, tt.salt, tt.iter, got, tt.want)
+		}
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	stored, err := hashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stored, "pbkdf2-sha256$100000$") {
+		t.Fatalf("hash %q lacks its scheme and work factor", stored)
+	}
+	if again, _ := hashPassword("hunter2"); again == stored {
+		t.Fatal("two hashes of one password share a salt")
+	}
+
+	tests := []struct {
+		pass, stored string
+		want         bool
+	}{
+		{"hunter2", stored, true},
+		{"hunter3", stored, false},
+		{"", stored, false},
+		{"hunter2", "", false},
+		{"hunter2", strings.Replace(stored, "pbkdf2-sha256", "pbkdf2-sha1", 1), false},
+		{"hunter2", strings.Replace(stored, "$100000$", "$0$", 1), false},
+		{"hunter2", strings.Replace(stored, "$100000$", "$x$", 1), false},
+		{"hunter2", stored + "$", false},
+		{"hunter2", stored[:strings.LastIndexByte(stored, '$')] + "$!!", false},
+	}
+	for _, tt := range tests {
+		if got := checkPassword(tt.pass, tt.stored); got != tt.want {
+			t.Errorf("checkPassword(%q, %q) = %v, want %v", tt.pass, tt.stored, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Pastes per page of the recent index and feed
+const recentPage = 50
+
+// One entry of the recent index
+type recentEntry struct {
+	*Meta
+	URL   string
+	Title string
+}
+
+// A page of the recent public pastes, newest first
+func recentPastes(r *http.Request) ([]recentEntry, int, bool, error) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	metas, err := listMetas(func(m *Meta) bool { return m.Vis() == Public })
+	if err != nil {
+		return nil, page, false, err
+	}
+
+	start := (page - 1) * recentPage
+	if start > len(metas) {
+		start = len(metas)
+	}
+	end := start + recentPage
+	if end > len(metas) {
+		end = len(metas)
+	}
+
+	var entries []recentEntry
+	for _, m := range metas[start:end] {
+		entries = append(entries, recentEntry{m, proto + r.Host + "/" + m.Key, pasteTitle(m)})
+	}
+	return entries, page, end < len(metas), nil
+}
+
+// A short title for a paste — its first line, when the server may show it
+func pasteTitle(m *Meta) string {
+	switch {
+	case m.Bundle():
+		return strings.Join(m.Files, ", ")
+	case m.Encrypted, m.Pass != "", m.Burn:
+		return m.Key
+	}
+
+	data, err := readPaste(m.Key)
+	if err != nil {
+		return m.Key
+	}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		data = data[:i]
+	}
+	title := []rune(strings.TrimSpace(string(data)))
+	if len(title) > 72 {
+		title = append(title[:72], '…')
+	}
+	if len(title) == 0 {
+		return m.Key
+	}
+	return string(title)
+}
+
+// Recent index handler — plain text for curl, HTML for browsers
+func handleRecent(w http.ResponseWriter, r *http.Request) {
+	entries, page, more, err := recentPastes(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if wantsHTML(r) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		recentTmpl.Execute(w, struct {
+			Entries    []recentEntry
+			Prev, Next int
+		}{entries, page - 1, nextPage(page, more)})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", e.URL, e.Size, e.Created.Format(time.RFC3339))
+	}
+	if more {
+		fmt.Fprintf(w, "# more: %s%s/recent?page=%d\n", proto, r.Host, page+1)
+	}
+}
+
+// Atom feed handler for recent public pastes
+func handleRecentAtom(w http.ResponseWriter, r *http.Request) {
+	entries, page, more, err := recentPastes(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	base := proto + r.Host
+	feed := atomFeed{
+		NS:    "http://www.w3.org/2005/Atom",
+		ID:    base + "/recent.atom",
+		Title: strings.ToLower(manTitle) + ": recent pastes",
+		Links: []atomLink{
+			{Rel: "self", Href: fmt.Sprintf("%s/recent.atom?page=%d", base, page)},
+			{Rel: "alternate", Href: base + "/recent"},
+		},
+		Updated: time.Now().UTC().Format(time.RFC3339),
+	}
+	if page > 1 {
+		feed.Links = append(feed.Links, atomLink{Rel: "previous", Href: fmt.Sprintf("%s/recent.atom?page=%d", base, page-1)})
+	}
+	if more {
+		feed.Links = append(feed.Links, atomLink{Rel: "next", Href: fmt.Sprintf("%s/recent.atom?page=%d", base, page+1)})
+	}
+	if len(entries) > 0 {
+		feed.Updated = entries[0].Created.UTC().Format(time.RFC3339)
+	}
+
+	for _, e := range entries {
+		ae := atomEntry{
+			ID:      e.URL,
+			Title:   e.Title,
+			Link:    atomLink{Rel: "alternate", Href: e.URL},
+			Updated: e.Created.UTC().Format(time.RFC3339),
+			Summary: fmt.Sprintf("%d bytes", e.Size),
+		}
+		if e.Owner != "" {
+			ae.Author = &atomAuthor{Name: e.Owner, URI: base + "/~" + e.Owner}
+		}
+		feed.Entries = append(feed.Entries, ae)
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	fmt.Fprint(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	enc.Encode(feed)
+}
+
+// Next page number, or 0 on the last page
+func nextPage(page int, more bool) int {
+	if more {
+		return page + 1
+	}
+	return 0
+}
+
+// Atom (RFC 4287) document structure
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	NS      string      `xml:"xmlns,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Links   []atomLink  `xml:"link"`
+	Updated string      `xml:"updated"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomEntry struct {
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Link    atomLink    `xml:"link"`
+	Updated string      `xml:"updated"`
+	Author  *atomAuthor `xml:"author,omitempty"`
+	Summary string      `xml:"summary"`
+}
+
+// Recent index page for browsers
+var recentTmpl = template.Must(template.New("recent").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Recent pastes</title>
+<link rel="alternate" type="application/atom+xml" href="/recent.atom">
+<style>
+body { font-family: monospace; margin: 1em; }
+td { padding: 0 1em 0 0; }
+</style>
+</head>
+<body>
+<table>
+{{range .Entries}}<tr><td><a href="/{{.Key}}">{{.Title}}</a></td><td>{{if .Owner}}<a href="/~{{.Owner}}">{{.Owner}}</a>{{end}}</td><td>{{.Size}}</td><td>{{.Created.Format "2006-01-02 15:04"}}</td></tr>
+{{else}}<tr><td>No public pastes yet.</td></tr>
+{{end}}</table>
+<p>{{if .Prev}}<a href="/recent?page={{.Prev}}">newer</a> {{end}}{{if .Next}}<a href="/recent?page={{.Next}}">older</a>{{end}}</p>
+</body>
+</html>
+`))
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestReshard(t *testing.T) {
+	dir := t.TempDir()
+	flat := &dirStore{dir: dir}
+	for name, data := range map[string]string{
+		"abcdef.paste": "one",
+		"abcdef.meta":  "{}",
+		"abc":          "short", // too short to shard
+		"qrstuv.paste": "stale",
+	} {
+		flat.Put(name, []byte(data))
+	}
+
+	// A server in the new layout already rewrote this one, but died before
+	// removing the flat copy
+	sharded := &dirStore{dir: dir, shard: true}
+	os.MkdirAll(filepath.Join(dir, "qr", "st"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "qr", "st", "qrstuv.paste"), []byte("fresh"), 0600)
+
+	n, err := reshard(dir)
+	if err != nil || n != 2 {
+		t.Fatalf("moved %d, %v; want 2", n, err)
+	}
+	for name, p := range map[string]string{
+		"abcdef.paste": "ab/cd/abcdef.paste",
+		"abcdef.meta":  "ab/cd/abcdef.meta",
+		"abc":          "abc",
+		"qrstuv.paste": "qr/st/qrstuv.paste",
+	} {
+		if _, err := os.Stat(filepath.Join(dir, p)); err != nil {
+			t.Fatalf("%s not at %s: %v", name, p, err)
+		}
+	}
+	for _, name := range []string{"abcdef.paste", "qrstuv.paste"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Fatalf("flat %s left behind: %v", name, err)
+		}
+	}
+	if got, _ := sharded.Get("qrstuv.paste"); string(got) != "fresh" {
+		t.Fatalf("qrstuv.paste: %q, want the sharded copy kept", got)
+	}
+
+	names, err := sharded.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	if got := strings.Join(names, " "); got != "abc abcdef.meta abcdef.paste qrstuv.paste" {
+		t.Fatalf("listed %q", got)
+	}
+
+	if n, err := reshard(dir); err != nil || n != 0 {
+		t.Fatalf("second run moved %d, %v", n, err)
+	}
+}
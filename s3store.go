@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Objects in an S3-compatible bucket, addressed path-style so MinIO and
+// friends work as well as AWS. Metadata lives in <key>.meta sidecar objects
+// like any other store, and expired pastes are deleted by the reaper.
+type s3Store struct {
+	endpoint *url.URL // scheme and host
+	bucket   string
+	prefix   string // prepended to every name
+	region   string
+	access   string
+	secret   string
+	token    string // session token, if any
+	client   *http.Client
+}
+
+// Payload hash sent when the body isn't hashed up front
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// Open a bucket from https://host[:port]/bucket[/prefix], with credentials from
+// $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY and $AWS_SESSION_TOKEN and the
+// region from $AWS_REGION (default us-east-1)
+func openS3Store(loc string) (*s3Store, error) {
+	u, err := url.Parse(loc)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("bad s3 location %q: want http[s]://host[:port]/bucket[/prefix]", loc)
+	}
+	path := strings.Trim(u.Path, "/")
+	if path == "" {
+		return nil, fmt.Errorf("bad s3 location %q: no bucket", loc)
+	}
+
+	s := &s3Store{
+		endpoint: &url.URL{Scheme: u.Scheme, Host: u.Host},
+		bucket:   path,
+		region:   os.Getenv("AWS_REGION"),
+		access:   os.Getenv("AWS_ACCESS_KEY_ID"),
+		secret:   os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:    os.Getenv("AWS_SESSION_TOKEN"),
+		client:   &http.Client{Timeout: 5 * time.Minute},
+	}
+	if i := strings.IndexByte(path, '/'); i >= 0 {
+		s.bucket, s.prefix = path[:i], path[i+1:]+"/"
+	}
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+	if s.access == "" || s.secret == "" {
+		return nil, errors.New("s3: set $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY")
+	}
+	return s, nil
+}
+
+// Build a signed request for an object, or for the bucket when name is ""
+func (s *s3Store) request(method, name string, query url.Values, body io.Reader, payloadHash string) (*http.Request, error) {
+	u := *s.endpoint
+	u.Path = "/" + s.bucket + "/"
+	if name != "" {
+		u.Path += s.prefix + name
+	}
+	u.RawPath = s3Escape(u.Path, false)
+	u.RawQuery = s3Query(query)
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, payloadHash, time.Now().UTC())
+	return req, nil
+}
+
+// Sign a request with AWS Signature Version 4
+func (s *s3Store) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.token != "" {
+		req.Header.Set("X-Amz-Security-Token", s.token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if lk == "x-amz-date" || lk == "x-amz-content-sha256" || lk == "x-amz-security-token" || lk == "content-type" || lk == "range" {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canon strings.Builder
+	for _, k := range names {
+		canon.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	creq := strings.Join([]string{
+		req.Method,
+		s3Escape(req.URL.Path, false),
+		req.URL.RawQuery,
+		canon.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256String([]byte(creq))
+
+	key := hmacSHA256([]byte("AWS4"+s.secret), day)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.access, scope, signed, sig))
+}
+
+func hmacSHA256(key []byte, msg string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(msg))
+	return h.Sum(nil)
+}
+
+func sha256String(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// URI-encode as SigV4 wants: everything but unreserved characters, and / only if asked
+func s3Escape(s string, slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !slash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// Canonical query string: sorted, each key and value escaped
+func s3Query(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		for _, v := range q[k] {
+			parts = append(parts, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// Send a request, turning 404s into not-exist errors and other failures into S3's message
+func (s *s3Store) do(req *http.Request, name string) (*http.Response, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound && name != "" {
+		return nil, &os.PathError{Op: strings.ToLower(req.Method), Path: name, Err: os.ErrNotExist}
+	}
+	var e struct {
+		Code    string
+		Message string
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	if xml.Unmarshal(body, &e) == nil && e.Code != "" {
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", req.Method, name, e.Code, e.Message)
+	}
+	return nil, fmt.Errorf("s3 %s %s: %s", req.Method, name, resp.Status)
+}
+
+func (s *s3Store) Get(name string) ([]byte, error) {
+	rc, _, err := s.GetStream(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+func (s *s3Store) Put(name string, data []byte) error {
+	req, err := s.request("PUT", name, nil, bytes.NewReader(data), sha256String(data))
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, name)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Stream an object's body without buffering it
+func (s *s3Store) GetStream(name string) (io.ReadCloser, int64, error) {
+	req, err := s.request("GET", name, nil, nil, sha256String(nil))
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := s.do(req, name)
+	if err != nil {
+		return nil, 0, err
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// Upload an object of known size as it is read — the body is not hashed for the signature
+func (s *s3Store) PutStream(name string, r io.Reader, size int64) error {
+	req, err := s.request("PUT", name, nil, ioutil.NopCloser(r), unsignedPayload)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	resp, err := s.do(req, name)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3Store) Delete(name string) error {
+	req, err := s.request("DELETE", name, nil, nil, sha256String(nil))
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, name)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Names under the prefix, a page of ListObjectsV2 at a time
+func (s *s3Store) List() ([]string, error) {
+	var names []string
+	q := url.Values{"list-type": {"2"}}
+	if s.prefix != "" {
+		q.Set("prefix", s.prefix)
+	}
+
+	for {
+		req, err := s.request("GET", "", q, nil, sha256String(nil))
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.do(req, "")
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("s3 list: %v", err)
+		}
+
+		for _, c := range page.Contents {
+			name := strings.TrimPrefix(c.Key, s.prefix)
+			if name != "" && name[0] != '.' && !strings.Contains(name, "/") {
+				names = append(names, name)
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return names, nil
+		}
+		q.Set("continuation-token", page.NextContinuationToken)
+	}
+}
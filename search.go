@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Most results returned for one query
+const searchMax = 100
+
+// Longest term worth indexing
+const termMax = 64
+
+// In-memory inverted index of public paste contents and metadata
+type searchIndex struct {
+	sync.RWMutex
+	postings map[string]map[string][]int // term → key → positions
+	docs     map[string]*indexDoc
+}
+
+// An indexed paste and the terms it contributed
+type indexDoc struct {
+	meta  *Meta
+	terms []string
+}
+
+// The running index
+var index = newSearchIndex()
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: make(map[string]map[string][]int),
+		docs:     make(map[string]*indexDoc),
+	}
+}
+
+// Whether a paste belongs in the index — public, readable without secrets,
+// and not burned by reading
+func searchable(m *Meta) bool {
+	return m.Vis() == Public && !m.Encrypted && m.Pass == "" && !m.Burn && !m.Expired()
+}
+
+// Split text into lowercase terms
+func tokenize(s string) []string {
+	f := func(c rune) bool { return !unicode.IsLetter(c) && !unicode.IsDigit(c) }
+
+	var terms []string
+	for _, t := range strings.FieldsFunc(s, f) {
+		if len(t) <= termMax {
+			terms = append(terms, strings.ToLower(t))
+		}
+	}
+	return terms
+}
+
+// Text indexed for a paste — its metadata followed by its contents
+func indexText(m *Meta, data []byte) string {
+	var sb strings.Builder
+	sb.WriteString(m.Owner)
+	sb.WriteString(" ")
+	sb.WriteString(m.Lang)
+	sb.WriteString(" ")
+
+	if !m.Bundle() {
+		sb.Write(data)
+		return sb.String()
+	}
+
+	files, err := unpackBundle(data)
+	if err != nil {
+		return sb.String()
+	}
+	for _, f := range files {
+		sb.WriteString(f.Name)
+		sb.WriteString("\n")
+		sb.Write(f.Body)
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// Add or replace a paste in the index
+func (ix *searchIndex) add(m *Meta, data []byte) {
+	if !searchable(m) {
+		ix.remove(m.Key)
+		return
+	}
+
+	terms := tokenize(indexText(m, data))
+
+	ix.Lock()
+	defer ix.Unlock()
+
+	ix.removeLocked(m.Key)
+	doc := &indexDoc{meta: m}
+	for pos, t := range terms {
+		p := ix.postings[t]
+		if p == nil {
+			p = make(map[string][]int)
+			ix.postings[t] = p
+		}
+		if p[m.Key] == nil {
+			doc.terms = append(doc.terms, t)
+		}
+		p[m.Key] = append(p[m.Key], pos)
+	}
+	ix.docs[m.Key] = doc
+}
+
+// Drop a paste from the index
+func (ix *searchIndex) remove(key string) {
+	ix.Lock()
+	defer ix.Unlock()
+	ix.removeLocked(key)
+}
+
+func (ix *searchIndex) removeLocked(key string) {
+	doc := ix.docs[key]
+	if doc == nil {
+		return
+	}
+	for _, t := range doc.terms {
+		delete(ix.postings[t], key)
+		if len(ix.postings[t]) == 0 {
+			delete(ix.postings, t)
+		}
+	}
+	delete(ix.docs, key)
+}
+
+// Index every searchable paste in the store
+func (ix *searchIndex) rebuild() {
+	start := time.Now()
+	metas, err := listMetas(searchable)
+	if err != nil {
+		log.Printf("search: %v", err)
+		return
+	}
+
+	for _, m := range metas {
+		data, err := readPaste(m.Key)
+		if err != nil {
+			log.Printf("search: %s: %v", m.Key, err)
+			continue
+		}
+		ix.add(m, data)
+	}
+	log.Printf("search: indexed %d pastes in %v", len(metas), time.Since(start).Round(time.Millisecond))
+}
+
+// A parsed search: every phrase must occur, and filters must match
+type query struct {
+	phrases [][]string // single terms are one-word phrases
+	lang    string
+	owner   string
+}
+
+// Parse terms, "quoted phrases", lang:<lang> and user:<name>
+func parseQuery(q string) query {
+	var qq query
+	for len(q) > 0 {
+		q = strings.TrimLeft(q, " \t")
+		if q == "" {
+			break
+		}
+
+		var word string
+		if q[0] == '"' {
+			end := strings.IndexByte(q[1:], '"')
+			if end < 0 {
+				word, q = q[1:], ""
+			} else {
+				word, q = q[1:end+1], q[end+2:]
+			}
+			if p := tokenize(word); len(p) > 0 {
+				qq.phrases = append(qq.phrases, p)
+			}
+			continue
+		}
+
+		end := strings.IndexAny(q, " \t")
+		if end < 0 {
+			end = len(q)
+		}
+		word, q = q[:end], q[end:]
+
+		switch {
+		case strings.HasPrefix(word, "lang:"):
+			qq.lang = strings.ToLower(word[len("lang:"):])
+		case strings.HasPrefix(word, "user:"):
+			qq.owner = word[len("user:"):]
+		default:
+			for _, t := range tokenize(word) {
+				qq.phrases = append(qq.phrases, []string{t})
+			}
+		}
+	}
+	return qq
+}
+
+// Run a query, returning matching pastes newest first
+func (ix *searchIndex) search(qq query) []*Meta {
+	ix.RLock()
+	defer ix.RUnlock()
+
+	var hits []*Meta
+	for key, doc := range ix.docs {
+		m := doc.meta
+		if qq.lang != "" && strings.ToLower(m.Lang) != qq.lang {
+			continue
+		}
+		if qq.owner != "" && m.Owner != qq.owner {
+			continue
+		}
+		if m.Expired() || !ix.matchAll(key, qq.phrases) {
+			continue
+		}
+		hits = append(hits, m)
+	}
+
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Created.After(hits[j].Created) })
+	if len(hits) > searchMax {
+		hits = hits[:searchMax]
+	}
+	return hits
+}
+
+// Whether a paste contains every phrase
+func (ix *searchIndex) matchAll(key string, phrases [][]string) bool {
+	for _, p := range phrases {
+		if !ix.matchPhrase(key, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// Whether a paste contains the terms of a phrase at consecutive positions
+func (ix *searchIndex) matchPhrase(key string, phrase []string) bool {
+	first := ix.postings[phrase[0]][key]
+	if len(phrase) == 1 {
+		return len(first) > 0
+	}
+
+	next := make([]map[int]bool, len(phrase)-1)
+	for i, t := range phrase[1:] {
+		pos := ix.postings[t][key]
+		if len(pos) == 0 {
+			return false
+		}
+		next[i] = make(map[int]bool, len(pos))
+		for _, p := range pos {
+			next[i][p] = true
+		}
+	}
+
+outer:
+	for _, start := range first {
+		for i := range next {
+			if !next[i][start+i+1] {
+				continue outer
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// Search handler — GET /search?q=<terms, "phrases", lang:<lang>, user:<name>>
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	qq := parseQuery(q)
+	if len(qq.phrases) == 0 && qq.lang == "" && qq.owner == "" {
+		http.Error(w, "usage: /search?q=<terms> [\"a phrase\"] [lang:<lang>] [user:<name>]", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, m := range index.search(qq) {
+		lang := m.Lang
+		if lang == "" {
+			lang = "-"
+		}
+		fmt.Fprintf(w, "%s%s/%s\t%d\t%s\t%s\n", proto, r.Host, m.Key, m.Size, m.Created.Format(time.RFC3339), lang)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenize(t *testing.T) {
+	got := tokenize("Hello, wörld! foo_bar x2 " + strings.Repeat("a", termMax+1))
+	want := []string{"hello", "wörld", "foo", "bar", "x2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("tokenize = %q, want %q", got, want)
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		in   string
+		want query
+	}{
+		{"Foo bar", query{phrases: [][]string{{"foo"}, {"bar"}}}},
+		{`"hello world" x`, query{phrases: [][]string{{"hello", "world"}, {"x"}}}},
+		{`lang:Go user:alice "unterminated phrase`, query{phrases: [][]string{{"unterminated", "phrase"}}, lang: "go", owner: "alice"}},
+		{`"" , foo-bar`, query{phrases: [][]string{{"foo"}, {"bar"}}}},
+		{"  ", query{}},
+	}
+	for _, tt := range tests {
+		if got := parseQuery(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseQuery(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	ix := newSearchIndex()
+	now := time.Now()
+	add := func(key, owner, lang, body string, age time.Duration, m Meta) {
+		m.Key, m.Owner, m.Lang, m.Created = key, owner, lang, now.Add(-age)
+		if m.Visibility == "" {
+			m.Visibility = Public
+		}
+		ix.add(&m, []byte(body))
+	}
+
+	add("a", "alice", "go", "the quick brown fox", 3*time.Hour, Meta{})
+	add("b", "bob", "python", "brown quick dog; the fox", 2*time.Hour, Meta{})
+	add("c", "alice", "Go", "Quick brown: the fox jumps", time.Hour, Meta{})
+	add("private", "alice", "go", "quick brown fox", 0, Meta{Visibility: Private})
+	add("burn", "alice", "go", "quick brown fox", 0, Meta{Burn: true})
+	add("locked", "alice", "go", "quick brown fox", 0, Meta{Pass: "x"})
+	add("old", "alice", "go", "quick brown fox", 0, Meta{Expires: now.Add(-time.Minute)})
+
+	bundle, err := packBundle([]bundleFile{{"notes.txt", []byte("quick brown fox")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ix.add(&Meta{Key: "bundle", Visibility: Public, Created: now.Add(-4 * time.Hour), Files: []string{"notes.txt"}}, bundle)
+
+	keys := func(q string) string {
+		var k []string
+		for _, m := range ix.search(parseQuery(q)) {
+			k = append(k, m.Key)
+		}
+		return strings.Join(k, " ")
+	}
+	tests := []struct {
+		q, want string
+	}{
+		{"fox", "c b a bundle"},
+		{"FOX quick", "c b a bundle"},
+		{`"quick brown"`, "c a bundle"},
+		{`"quick brown fox"`, "a bundle"},
+		{`"brown quick" fox`, "b"},
+		{`"fox the"`, ""},
+		{"notes", "bundle"},
+		{"lang:go fox", "c a"},
+		{"user:bob", "b"},
+		{"user:alice lang:python", ""},
+		{"dog cat", ""},
+	}
+	for _, tt := range tests {
+		if got := keys(tt.q); got != tt.want {
+			t.Errorf("search %q = %q, want %q", tt.q, got, tt.want)
+		}
+	}
+
+	// Replacing a paste drops its old terms; making it private drops it entirely
+	add("a", "alice", "go", "slow green turtle", 3*time.Hour, Meta{})
+	ix.add(&Meta{Key: "b", Visibility: Private}, []byte("fox"))
+	ix.remove("c")
+	if got := keys("fox"); got != "bundle" {
+		t.Fatalf("after changes, fox found %q", got)
+	}
+	if got := keys("turtle"); got != "a" {
+		t.Fatalf("after changes, turtle found %q", got)
+	}
+	if _, ok := ix.postings["dog"]; ok {
+		t.Fatal("a removed paste's terms linger in the index")
+	}
+}
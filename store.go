@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Backing storage for pastes and their metadata, addressed by file name
+// Missing names are reported with errors satisfying os.IsNotExist
+type Store interface {
+	Get(name string) ([]byte, error)
+	Put(name string, data []byte) error
+	Delete(name string) error
+	List() ([]string, error)
+}
+
+// Stores that can move a blob without holding all of it in memory
+type streamStore interface {
+	GetStream(name string) (io.ReadCloser, int64, error)
+	PutStream(name string, r io.Reader, size int64) error
+}
+
+// Streams over any store — for stores that can only hand over whole blobs
+type blobStreams struct {
+	Store
+}
+
+func (s blobStreams) GetStream(name string) (io.ReadCloser, int64, error) {
+	data, err := s.Get(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+}
+
+func (s blobStreams) PutStream(name string, r io.Reader, size int64) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return s.Put(name, data)
+}
+
+// A store's streams, native or not
+func streamsOf(s Store) streamStore {
+	if ss, ok := s.(streamStore); ok {
+		return ss
+	}
+	return blobStreams{s}
+}
+
+// Open a store from a spec like dir:/srv/pastes, shard:/srv/pastes, db:/srv/pastes.db
+// or s3:https://host/bucket/prefix
+func openBackend(spec string) (Store, error) {
+	i := strings.IndexByte(spec, ':')
+	if i < 0 {
+		return nil, fmt.Errorf("bad store %q: want <kind>:<location>, e.g. dir:/srv/pastes", spec)
+	}
+
+	kind, loc := spec[:i], spec[i+1:]
+	switch kind {
+	case "dir":
+		return &dirStore{dir: loc}, nil
+	case "shard":
+		return &dirStore{dir: loc, shard: true}, nil
+	case "db":
+		return openDBStore(loc)
+	case "s3":
+		return openS3Store(loc)
+	}
+	return nil, fmt.Errorf("unknown store kind %q in %q", kind, spec)
+}
+
+// Directory on the file system holding a store's data, "" if it has none
+func storeDir(s Store) string {
+	switch s := s.(type) {
+	case *dirStore:
+		return s.dir
+	case *dbStore:
+		return filepath.Dir(s.path)
+	}
+	return ""
+}
+
+// Open the lock file processes sharing a directory store take around changes
+// spanning several names, or nil for other stores and unwritable directories
+func openStoreLock(s Store) *os.File {
+	d, ok := s.(*dirStore)
+	if !ok {
+		return nil
+	}
+	f, err := os.OpenFile(filepath.Join(d.dir, ".lock"), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil
+	}
+	return f
+}
+
+// Directory of files — flat, the original layout, or sharded as ab/cd/<name>
+// Sharded stores still find, list and delete files left in the flat layout
+type dirStore struct {
+	dir   string
+	shard bool
+}
+
+// Where a name lives — hidden names and short ones always stay flat
+func (s *dirStore) path(name string) string {
+	if !s.shard || len(name) < 4 || name[0] == '.' {
+		return filepath.Join(s.dir, name)
+	}
+	return filepath.Join(s.dir, name[:2], name[2:4], name)
+}
+
+func (s *dirStore) Get(name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(s.path(name))
+	if os.IsNotExist(err) && s.shard {
+		return ioutil.ReadFile(filepath.Join(s.dir, name))
+	}
+	return data, err
+}
+
+func (s *dirStore) Put(name string, data []byte) error {
+	return s.PutStream(name, bytes.NewReader(data), int64(len(data)))
+}
+
+func (s *dirStore) GetStream(name string) (io.ReadCloser, int64, error) {
+	f, err := os.Open(s.path(name))
+	if os.IsNotExist(err) && s.shard {
+		f, err = os.Open(filepath.Join(s.dir, name))
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, fi.Size(), nil
+}
+
+// Write to a temporary file and rename so readers never see partial pastes
+func (s *dirStore) PutStream(name string, r io.Reader, size int64) error {
+	p := s.path(name)
+	if s.shard {
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return err
+		}
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(p), ".tmp-")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0600)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	// A flat copy left from before sharding would now be stale
+	if flat := filepath.Join(s.dir, name); s.shard && flat != p {
+		os.Remove(flat)
+	}
+	return nil
+}
+
+func (s *dirStore) Delete(name string) error {
+	err := os.Remove(s.path(name))
+	if os.IsNotExist(err) && s.shard {
+		return os.Remove(filepath.Join(s.dir, name))
+	}
+	return err
+}
+
+func (s *dirStore) List() ([]string, error) {
+	if !s.shard {
+		return listDir(s.dir)
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+	err := filepath.Walk(s.dir, func(p string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) && p != s.dir {
+			return nil // deleted while we walked
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() && fi.Name()[0] != '.' && !seen[fi.Name()] {
+			seen[fi.Name()] = true
+			names = append(names, fi.Name())
+		}
+		return nil
+	})
+	return names, err
+}
+
+// Regular, unhidden files directly in a directory
+func listDir(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, fi := range infos {
+		if fi.Mode().IsRegular() && fi.Name()[0] != '.' {
+			names = append(names, fi.Name())
+		}
+	}
+	return names, nil
+}
+
+// Move every file of a flat directory into its shard, returning how many moved
+// Each move is a rename, so a server on the same directory keeps finding them throughout
+func reshard(dir string) (int, error) {
+	names, err := listDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	s := &dirStore{dir: dir, shard: true}
+
+	// Hold the store lock so a reference count read mid-move isn't taken for missing
+	if lf := openStoreLock(s); lf != nil {
+		defer lf.Close()
+		if err := waitLockFile(lf); err != nil {
+			return 0, err
+		}
+	}
+
+	var n int
+	for _, name := range names {
+		p := s.path(name)
+		if p == filepath.Join(dir, name) {
+			continue
+		}
+		// Already sharded by a server writing in the new layout — the flat copy is stale
+		if _, err := os.Stat(p); err == nil {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil {
+				return n, err
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return n, err
+		}
+		if err := os.Rename(filepath.Join(dir, name), p); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Paste visibility levels
+const (
+	Public   = "public"   // listed in indexes
+	Unlisted = "unlisted" // readable by anyone with the key
+	Private  = "private"  // readable only by the owner or uploading API key
+)
+
+// Parse a visibility form value, defaulting to unlisted
+func parseVisibility(s string) (string, error) {
+	switch s {
+	case "":
+		return Unlisted, nil
+	case Public, Unlisted, Private:
+		return s, nil
+	}
+	return "", fmt.Errorf("bad visibility %q: want public, unlisted or private", s)
+}
+
+// The paste's visibility — pastes from before visibility existed are unlisted
+func (m *Meta) Vis() string {
+	if m.Visibility == "" {
+		return Unlisted
+	}
+	return m.Visibility
+}
+
+// Whether the requester owns the paste, by account or by API key
+func ownsPaste(r *http.Request, m *Meta) bool {
+	if m.Owner != "" && currentUser(r) == m.Owner {
+		return true
+	}
+	if m.Label != "" && apiKeys != nil {
+		if k := apiKeys.lookup(requestToken(r)); k != nil && k.Label == m.Label {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the requester may read the paste at all
+func canView(r *http.Request, m *Meta) bool {
+	return m.Vis() != Private || ownsPaste(r, m)
+}
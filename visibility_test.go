@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseVisibility(t *testing.T) {
+	tests := []struct {
+		in, want string
+		ok       bool
+	}{
+		{"", Unlisted, true},
+		{"public", Public, true},
+		{"unlisted", Unlisted, true},
+		{"private", Private, true},
+		{"Public", "", false},
+		{"secret", "", false},
+	}
+	for _, tt := range tests {
+		got, err := parseVisibility(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseVisibility(%q) = %q, %v; want %q, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+
+	if got := (&Meta{}).Vis(); got != Unlisted {
+		t.Errorf("paste from before visibility: %q, want unlisted", got)
+	}
+}
+
+func TestCanView(t *testing.T) {
+	dir := t.TempDir()
+	defer func(u *userdb, k *keyring, s []byte) { users, apiKeys, sessionKey = u, k, s }(users, apiKeys, sessionKey)
+
+	users = newUserdb(filepath.Join(dir, "users"))
+	if err := users.set("alice", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	users.set("bob", "hunter2")
+	if err := initSessions(filepath.Join(dir, "session.key")); err != nil {
+		t.Fatal(err)
+	}
+	apiKeys = &keyring{keys: []apiKey{{Token: "citoken", Label: "ci"}, {Token: "other", Label: "laptop"}}}
+
+	as := func(user string) func(*http.Request) {
+		return func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: "session", Value: signSession(user, time.Now().Add(time.Hour))})
+		}
+	}
+	withKey := func(token string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+	basic := func(user, pass string) func(*http.Request) {
+		return func(r *http.Request) { r.SetBasicAuth(user, pass) }
+	}
+	anon := func(*http.Request) {}
+
+	owned := &Meta{Owner: "alice", Visibility: Private}
+	labelled := &Meta{Label: "ci", Visibility: Private}
+	tests := []struct {
+		m    *Meta
+		who  func(*http.Request)
+		want bool
+	}{
+		{&Meta{Visibility: Public}, anon, true},
+		{&Meta{}, anon, true},
+		{&Meta{Owner: "alice"}, as("bob"), true},
+		{owned, anon, false},
+		{owned, as("alice"), true},
+		{owned, as("bob"), false},
+		{owned, as("mallory"), false}, // signed, but no such account
+		{owned, basic("alice", "secret"), true},
+		{owned, basic("alice", "wrong"), false},
+		{owned, withKey("citoken"), false},
+		{labelled, withKey("citoken"), true},
+		{labelled, withKey("other"), false},
+		{labelled, as("alice"), false},
+		{&Meta{Visibility: Private}, as("alice"), false}, // nobody owns it
+	}
+	for i, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		tt.who(r)
+		if got := canView(r, tt.m); got != tt.want {
+			t.Errorf("case %d: canView(owner %q, label %q, %s) = %v, want %v", i, tt.m.Owner, tt.m.Label, tt.m.Vis(), got, tt.want)
+		}
+	}
+}